@@ -0,0 +1,250 @@
+-- balancer picks the peer of each upstream from the endpoints stored by
+-- configuration, weight and endpoints changes are applied without reloading.
+local ngx_balancer = require("ngx.balancer")
+local cjson = require("cjson.safe")
+local resty_roundrobin = require("resty.roundrobin")
+local resty_chash = require("resty.chash")
+local configuration = require("canary.configuration")
+local monitor = require("canary.monitor")
+
+-- interval of syncing backends from the shared dict in seconds
+local BACKENDS_SYNC_INTERVAL = 1
+-- prefix of the keys counting failed tries of peers
+local FAILS_PREFIX = "fails:"
+
+local _M = {}
+
+-- balancers of upstreams in this worker indexed by upstream name
+local balancers = {}
+-- raw data of the backends applied in this worker
+local backends_data = nil
+
+local function state_dict()
+  if ngx.config.subsystem == "stream" then
+    return ngx.shared.canary_stream_balancer
+  end
+  return ngx.shared.canary_balancer
+end
+
+-- base_name returns the name of the weighted upstream of a side upstream
+local function base_name(name)
+  local base = string.gsub(name, "%-canary$", "")
+  base = string.gsub(base, "%-origin$", "")
+  return base
+end
+
+local function build_balancer(backend)
+  local nodes = {}
+  local peers = {}
+  local keys = {}
+  local endpoints = backend.endpoints
+  if type(endpoints) ~= "table" then
+    endpoints = {}
+  end
+
+  for _, endpoint in ipairs(endpoints) do
+    local key = endpoint.address .. ":" .. tostring(endpoint.port)
+    nodes[key] = endpoint.weight
+    peers[key] = { address = endpoint.address, port = endpoint.port, canary = endpoint.canary }
+    table.insert(keys, key)
+  end
+
+  local balancer = {
+    name = backend.name,
+    hash_key = backend.hashKey,
+    canary_weight = backend.canaryWeight or 0,
+    max_fails = backend.maxFails or 0,
+    fail_timeout = backend.failTimeout or 0,
+    peers = peers,
+    keys = keys,
+  }
+  if next(nodes) == nil then
+    return balancer
+  end
+
+  if backend.hashKey and backend.hashKey ~= "" then
+    -- hash key is a nginx variable such as $remote_addr
+    balancer.hash_key = string.sub(backend.hashKey, 2)
+    balancer.instance = resty_chash:new(nodes)
+  else
+    balancer.hash_key = nil
+    balancer.instance = resty_roundrobin:new(nodes)
+  end
+  return balancer
+end
+
+local function sync_backends()
+  local data = configuration.get_backends_data()
+  if not data or data == backends_data then
+    return
+  end
+
+  local backends, err = cjson.decode(data)
+  if not backends then
+    ngx.log(ngx.ERR, "could not parse backends data: ", err)
+    return
+  end
+
+  local new_balancers = {}
+  if type(backends) == "table" then
+    for _, backend in ipairs(backends) do
+      new_balancers[backend.name] = build_balancer(backend)
+    end
+  end
+  for name, balancer in pairs(new_balancers) do
+    -- traffic of the weighted upstream fails over to origin, side upstreams
+    -- are chosen explicitly and only fail over within the side
+    if base_name(name) == name then
+      balancer.failover = new_balancers[name .. "-origin"]
+    end
+  end
+
+  balancers = new_balancers
+  backends_data = data
+end
+
+function _M.init_worker()
+  -- workers split sticky clients independently
+  math.randomseed(ngx.now() * 1000 + ngx.worker.pid())
+  sync_backends()
+
+  local ok, err = ngx.timer.every(BACKENDS_SYNC_INTERVAL, sync_backends)
+  if not ok then
+    ngx.log(ngx.ERR, "error when setting up timer.every for sync_backends: ", err)
+  end
+end
+
+-- is_down checks whether the peer failed max_fails times recently
+local function is_down(balancer, key)
+  if balancer.max_fails <= 0 or balancer.fail_timeout <= 0 then
+    return false
+  end
+  local fails = state_dict():get(FAILS_PREFIX .. key) or 0
+  return fails >= balancer.max_fails
+end
+
+local function usable(balancer, key, exclude)
+  return key ~= nil and key ~= exclude and not is_down(balancer, key)
+end
+
+local function pick(balancer)
+  if balancer.hash_key then
+    return balancer.instance:find(ngx.var[balancer.hash_key] or "")
+  end
+  return balancer.instance:find()
+end
+
+-- find_peer returns the balancer and the key of the peer to try. Peers which
+-- are down or excluded are skipped, a skipped peer of the weighted upstream
+-- fails over to origin. If all peers are down the first pick is tried anyway.
+local function find_peer(balancer, exclude)
+  local key = pick(balancer)
+  if usable(balancer, key, exclude) then
+    return balancer, key
+  end
+
+  local failover = balancer.failover
+  if failover and failover.instance then
+    local origin_key = pick(failover)
+    if usable(failover, origin_key, exclude) then
+      return failover, origin_key
+    end
+    for _, k in ipairs(failover.keys) do
+      if usable(failover, k, exclude) then
+        return failover, k
+      end
+    end
+  end
+
+  for _, k in ipairs(balancer.keys) do
+    if usable(balancer, k, exclude) then
+      return balancer, k
+    end
+  end
+  return balancer, key
+end
+
+-- record_failure counts a failed try of the peer. The peer is down for
+-- fail_timeout seconds once it fails max_fails times in fail_timeout seconds.
+local function record_failure(tried)
+  monitor.failure(tried.upstream, tried.side)
+
+  local balancer = tried.balancer
+  if balancer.max_fails <= 0 or balancer.fail_timeout <= 0 then
+    return
+  end
+  local dict = state_dict()
+  local key = FAILS_PREFIX .. tried.key
+  local fails, err = dict:incr(key, 1, 0, balancer.fail_timeout)
+  if not fails then
+    ngx.log(ngx.WARN, "error counting failures of peer ", tried.key, ": ", err)
+    return
+  end
+  if fails == balancer.max_fails then
+    -- the peer is down for a whole fail_timeout from now
+    dict:set(key, fails, balancer.fail_timeout)
+    ngx.log(ngx.WARN, "peer ", tried.key, " of upstream ", balancer.name, " is down for ", balancer.fail_timeout, "s")
+  end
+end
+
+-- balance sets the peer of the current request to the upstream
+function _M.balance(name)
+  local ctx = ngx.ctx
+  local exclude
+  if ctx.canary_tried then
+    -- balance is called again only if the last try failed
+    record_failure(ctx.canary_tried)
+    exclude = ctx.canary_tried.key
+  end
+
+  local balancer = balancers[name]
+  if not balancer or not balancer.instance then
+    ngx.log(ngx.WARN, "no endpoints for upstream ", name)
+    return ngx.exit(ngx.ERROR)
+  end
+
+  local peer_balancer, key = find_peer(balancer, exclude)
+  local peer = peer_balancer.peers[key]
+  local side = peer.canary and "canary" or "origin"
+  -- monitor labels the request with the side of the last tried peer
+  ctx.canary_side = side
+
+  if not ctx.canary_tried then
+    ngx_balancer.set_more_tries(1)
+  end
+  ctx.canary_tried = { balancer = peer_balancer, key = key, upstream = base_name(name), side = side }
+  monitor.track(ctx.canary_tried.upstream, side)
+
+  local ok, err = ngx_balancer.set_current_peer(peer.address, peer.port)
+  if not ok then
+    ngx.log(ngx.ERR, "error while setting current upstream peer ", peer.address, ":", peer.port, ": ", err)
+  end
+end
+
+-- after_request counts the failure of the last tried peer in the log phase,
+-- which is not retried
+function _M.after_request()
+  local tried = ngx.ctx.canary_tried
+  if not tried then
+    return
+  end
+  local status = tonumber(ngx.var.status) or 0
+  if status == 502 or status == 504 then
+    record_failure(tried)
+  end
+end
+
+-- split returns the side of a new sticky client by the canary weight of the upstream
+function _M.split(name)
+  local balancer = balancers[name]
+  if not balancer then
+    return "origin"
+  end
+
+  if math.random() * 100 < balancer.canary_weight then
+    return "canary"
+  end
+  return "origin"
+end
+
+return _M
@@ -0,0 +1,122 @@
+-- configuration receives the endpoints of all upstreams from nginx-proxy
+-- and stores them in the shared dict, balancer picks them up periodically.
+local cjson = require("cjson.safe")
+
+local _M = {}
+
+local function shared_dict()
+  if ngx.config.subsystem == "stream" then
+    return ngx.shared.canary_stream_configuration
+  end
+  return ngx.shared.canary_configuration
+end
+
+local function store_backends(data)
+  local backends, err = cjson.decode(data)
+  if not backends then
+    return "invalid backends: " .. tostring(err)
+  end
+
+  local success, err = shared_dict():set("backends", data)
+  if not success then
+    return "error updating backends: " .. tostring(err)
+  end
+  return nil
+end
+
+local function fetch_request_body()
+  ngx.req.read_body()
+  local body = ngx.req.get_body_data()
+
+  if not body then
+    -- request body is buffered to a temporary file if it's too large
+    local file_name = ngx.req.get_body_file()
+    if not file_name then
+      return nil
+    end
+
+    local file = io.open(file_name, "rb")
+    if not file then
+      return nil
+    end
+    body = file:read("*all")
+    file:close()
+  end
+
+  return body
+end
+
+local function http_call()
+  if ngx.var.request_uri ~= "/configuration/backends" then
+    ngx.status = ngx.HTTP_NOT_FOUND
+    ngx.print("Not found!")
+    return
+  end
+
+  if ngx.var.request_method == "GET" then
+    ngx.status = ngx.HTTP_OK
+    ngx.print(_M.get_backends_data() or "")
+    return
+  end
+
+  if ngx.var.request_method ~= "POST" then
+    ngx.status = ngx.HTTP_BAD_REQUEST
+    ngx.print("Only POST and GET requests are allowed!")
+    return
+  end
+
+  local body = fetch_request_body()
+  if not body then
+    ngx.status = ngx.HTTP_BAD_REQUEST
+    ngx.print("dynamic-configuration: unable to read valid request body")
+    return
+  end
+
+  local err = store_backends(body)
+  if err then
+    ngx.log(ngx.ERR, err)
+    ngx.status = ngx.HTTP_BAD_REQUEST
+    ngx.print(err)
+    return
+  end
+
+  ngx.status = ngx.HTTP_CREATED
+end
+
+local function stream_call()
+  local sock, err = ngx.req.socket(true)
+  if not sock then
+    ngx.log(ngx.ERR, "failed to get raw request socket: ", err)
+    return
+  end
+
+  -- nginx-proxy closes its side of the connection after sending all data
+  local data, err = sock:receive("*a")
+  if not data then
+    ngx.log(ngx.ERR, "failed to read backends from socket: ", err)
+    return
+  end
+
+  err = store_backends(data)
+  if err then
+    ngx.log(ngx.ERR, err)
+    sock:send(err)
+    return
+  end
+
+  sock:send("OK")
+end
+
+function _M.get_backends_data()
+  return shared_dict():get("backends")
+end
+
+function _M.call()
+  if ngx.config.subsystem == "stream" then
+    stream_call()
+    return
+  end
+  http_call()
+end
+
+return _M
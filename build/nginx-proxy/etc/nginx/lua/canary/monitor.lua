@@ -0,0 +1,135 @@
+-- monitor counts the traffic of each side of upstreams in the shared dict,
+-- nginx-proxy reads them and exposes Prometheus metrics.
+local cjson = require("cjson.safe")
+
+-- upper bounds in seconds of the latency histogram, the same as nginx-proxy
+local LATENCY_BUCKETS = { 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10 }
+
+local _M = {}
+
+local function shared_dict()
+  if ngx.config.subsystem == "stream" then
+    return ngx.shared.canary_stream_metrics
+  end
+  return ngx.shared.canary_metrics
+end
+
+local function incr(dict, key, value)
+  local _, err = dict:incr(key, value, 0)
+  if err then
+    ngx.log(ngx.WARN, "error increasing metric ", key, ": ", err)
+  end
+end
+
+-- parse_time returns the sum of times like "0.001, 0.002 : 0.003",
+-- every tried upstream server adds a time
+local function parse_time(value)
+  if not value then
+    return nil
+  end
+  local sum = nil
+  for t in string.gmatch(value, "[%d%.]+") do
+    sum = (sum or 0) + tonumber(t)
+  end
+  return sum
+end
+
+local function observe_latency(dict, prefix, value)
+  if not value then
+    return
+  end
+  for i, le in ipairs(LATENCY_BUCKETS) do
+    if value <= le then
+      incr(dict, prefix .. "latency_bucket|" .. (i - 1), 1)
+      break
+    end
+  end
+  incr(dict, prefix .. "latency_sum", value)
+  incr(dict, prefix .. "latency_count", 1)
+end
+
+-- failure counts a failed try to a peer of the side
+function _M.failure(upstream, side)
+  incr(shared_dict(), upstream .. "|" .. side .. "|failures", 1)
+end
+
+-- track counts the request or session as active on the side of the peer,
+-- it moves to the side of the new peer when the request is retried.
+-- Subrequests like mirrored requests are not counted, they never run the
+-- log phase to finish the count.
+function _M.track(upstream, side)
+  if ngx.config.subsystem == "http" and ngx.is_subrequest then
+    return
+  end
+  local dict = shared_dict()
+  local active = ngx.ctx.canary_active
+  if active then
+    incr(dict, active, -1)
+  end
+  active = upstream .. "|" .. side .. "|active"
+  incr(dict, active, 1)
+  ngx.ctx.canary_active = active
+end
+
+-- call records the request or session in the log phase
+function _M.call(upstream)
+  local side = ngx.ctx.canary_side
+  if not side then
+    -- no peer is picked
+    return
+  end
+
+  local dict = shared_dict()
+  if ngx.ctx.canary_active then
+    incr(dict, ngx.ctx.canary_active, -1)
+  end
+  local prefix = upstream .. "|" .. side .. "|"
+  local status = tonumber(ngx.var.status) or 0
+  if status >= 500 then
+    incr(dict, prefix .. "errors", 1)
+  end
+
+  if ngx.config.subsystem == "stream" then
+    incr(dict, prefix .. "connections", 1)
+    incr(dict, prefix .. "received_bytes", tonumber(ngx.var.bytes_received) or 0)
+    incr(dict, prefix .. "sent_bytes", tonumber(ngx.var.bytes_sent) or 0)
+    observe_latency(dict, prefix, parse_time(ngx.var.upstream_connect_time))
+    return
+  end
+
+  incr(dict, prefix .. "requests", 1)
+  incr(dict, prefix .. "received_bytes", tonumber(ngx.var.request_length) or 0)
+  incr(dict, prefix .. "sent_bytes", tonumber(ngx.var.bytes_sent) or 0)
+  observe_latency(dict, prefix, parse_time(ngx.var.upstream_response_time))
+end
+
+-- dump returns all metrics as a JSON object
+local function dump()
+  local dict = shared_dict()
+  local metrics = {}
+  for _, key in ipairs(dict:get_keys(0)) do
+    metrics[key] = dict:get(key)
+  end
+  if next(metrics) == nil then
+    return "{}"
+  end
+  return cjson.encode(metrics)
+end
+
+-- serve writes the metrics to the client
+function _M.serve()
+  if ngx.config.subsystem == "stream" then
+    local sock, err = ngx.req.socket(true)
+    if not sock then
+      ngx.log(ngx.ERR, "failed to get raw request socket: ", err)
+      return
+    end
+    sock:send(dump())
+    return
+  end
+
+  ngx.header.content_type = "application/json"
+  ngx.print(dump())
+end
+
+return _M
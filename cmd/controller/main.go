@@ -24,6 +24,7 @@ import (
 	"time"
 
 	crcontroller "github.com/caicloud/canary-release/controller/controller"
+	"github.com/caicloud/canary-release/pkg/election"
 	"github.com/caicloud/canary-release/pkg/version"
 	"github.com/caicloud/clientset/kubernetes"
 
@@ -33,6 +34,9 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+// leaseName is the name of the lease controller replicas elect a leader by
+const leaseName = "canary-release-controller"
+
 // RunController start lb controller
 func RunController(opts *Options, stopCh <-chan struct{}) error {
 
@@ -40,8 +44,9 @@ func RunController(opts *Options, stopCh <-chan struct{}) error {
 	log.Noticef("Controller Build Information %v", info.Pretty())
 
 	log.Info("Controller Running with", log.Fields{
-		"debug":     opts.Debug,
-		"kubconfig": opts.Kubeconfig,
+		"debug":       opts.Debug,
+		"kubconfig":   opts.Kubeconfig,
+		"leaderElect": opts.LeaderElection.Enabled,
 	})
 
 	if opts.Debug {
@@ -64,6 +69,45 @@ func RunController(opts *Options, stopCh <-chan struct{}) error {
 	// start a controller on instances of lb
 	controller := crcontroller.NewCanaryReleaseController(opts.Cfg)
 
+	if !opts.LeaderElection.Enabled {
+		controller.Run(5, stopCh)
+		return nil
+	}
+
+	// only the leader runs controller, other replicas wait to take over
+	identity, err := os.Hostname()
+	if err != nil {
+		log.Fatal("Error get hostname as identity of leader election", log.Fields{"err": err})
+		return err
+	}
+	leading := make(chan struct{})
+	elector := election.NewElector(election.Config{
+		Client:        opts.Cfg.Client.CoordinationV1(),
+		Namespace:     opts.LeaderElection.Namespace,
+		Name:          leaseName,
+		Identity:      identity,
+		LeaseDuration: opts.LeaderElection.LeaseDuration,
+		OnChange: func(leader bool) {
+			if !leader {
+				select {
+				case <-stopCh:
+					// the lease is released when stopping
+					return
+				default:
+				}
+				// informers and queue can't be restarted, exit to start over
+				log.Fatal("Leadership lost, exit")
+			}
+			close(leading)
+		},
+	})
+	go elector.Run(stopCh)
+
+	select {
+	case <-leading:
+	case <-stopCh:
+		return nil
+	}
 	controller.Run(5, stopCh)
 
 	return nil
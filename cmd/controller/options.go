@@ -17,16 +17,31 @@ limitations under the License.
 package main
 
 import (
+	"time"
+
 	"github.com/caicloud/canary-release/controller/config"
+	"github.com/caicloud/canary-release/pkg/election"
 	log "github.com/zoumo/logdog"
 	"gopkg.in/urfave/cli.v1"
 )
 
+const (
+	defaultLeaderElectionNamespace = "kube-system"
+)
+
 // Options contains controller options
 type Options struct {
-	Kubeconfig string
-	Debug      bool
-	Cfg        config.Configuration
+	Kubeconfig     string
+	Debug          bool
+	Cfg            config.Configuration
+	LeaderElection LeaderElection
+}
+
+// LeaderElection contains options of leader election among controller replicas
+type LeaderElection struct {
+	Enabled       bool
+	LeaseDuration time.Duration
+	Namespace     string
 }
 
 // NewOptions reutrns a new Options
@@ -54,6 +69,26 @@ func (opts *Options) AddFlags(app *cli.App) {
 			Usage:       "Force log to output with colore",
 			Destination: &log.ForceColor,
 		},
+		cli.BoolTFlag{
+			Name:        "leader-elect",
+			Usage:       "Elect a leader among replicas of controller, only the leader runs",
+			EnvVar:      "LEADER_ELECT",
+			Destination: &opts.LeaderElection.Enabled,
+		},
+		cli.DurationFlag{
+			Name:        "leader-elect-lease-duration",
+			Usage:       "`Duration` that non-leader replicas wait before taking over leadership",
+			EnvVar:      "LEADER_ELECT_LEASE_DURATION",
+			Value:       election.DefaultLeaseDuration,
+			Destination: &opts.LeaderElection.LeaseDuration,
+		},
+		cli.StringFlag{
+			Name:        "leader-elect-namespace",
+			Usage:       "`Namespace` of the lease for leader election",
+			EnvVar:      "LEADER_ELECT_NAMESPACE",
+			Value:       defaultLeaderElectionNamespace,
+			Destination: &opts.LeaderElection.Namespace,
+		},
 	}
 
 	app.Flags = append(app.Flags, flags...)
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"sort"
@@ -10,7 +11,9 @@ import (
 	"time"
 
 	"github.com/caicloud/canary-release/pkg/version"
+	"github.com/caicloud/canary-release/proxies/l4"
 	proxyctl "github.com/caicloud/canary-release/proxies/nginx/controller"
+	"github.com/caicloud/canary-release/proxies/provider"
 	"github.com/caicloud/clientset/kubernetes"
 	"github.com/caicloud/rudder/pkg/kube"
 
@@ -21,6 +24,11 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+const (
+	providerNginx = "nginx"
+	providerL4    = "l4"
+)
+
 // RunController start lb controller
 func RunController(opts *Options) error {
 	info := version.Get()
@@ -31,6 +39,7 @@ func RunController(opts *Options) error {
 		"kubconfig":   opts.Kubeconfig,
 		"crname":      opts.Cfg.CanaryReleaseName,
 		"crnamespace": opts.Cfg.CanaryReleaseNamespace,
+		"provider":    opts.TrafficProvider,
 	})
 
 	if opts.Debug {
@@ -70,8 +79,18 @@ func RunController(opts *Options) error {
 	opts.Cfg.ReleaseClient = client
 	opts.Cfg.ReleaseClientPool = pool
 
+	tp, err := newTrafficProvider(opts.TrafficProvider)
+	if err != nil {
+		log.Fatal("Create traffic provider error", log.Fields{"err": err})
+		return err
+	}
+
+	if collector, ok := tp.(provider.StatsCollector); ok && opts.MetricsAddress != "" {
+		go serveMetrics(opts.MetricsAddress, collector)
+	}
+
 	// start a controller on instances of lb
-	controller := proxyctl.NewProxy(opts.Cfg)
+	controller := proxyctl.NewProxy(opts.Cfg, tp)
 	// handle shutdown
 	go handleSigterm(controller)
 
@@ -82,6 +101,30 @@ func RunController(opts *Options) error {
 	return nil
 }
 
+// newTrafficProvider returns the traffic provider with the given name
+func newTrafficProvider(name string) (provider.TrafficProvider, error) {
+	switch name {
+	case providerNginx:
+		return proxyctl.NewNginxController(), nil
+	case providerL4:
+		return l4.NewProxy(), nil
+	default:
+		return nil, fmt.Errorf("unknown traffic provider %q", name)
+	}
+}
+
+// serveMetrics exposes the traffic stats in Prometheus format on /metrics
+func serveMetrics(address string, collector provider.StatsCollector) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", provider.MetricsHandler(collector))
+
+	log.Info("Serving metrics", log.Fields{"address": address})
+	err := http.ListenAndServe(address, mux)
+	if err != nil {
+		log.Error("Error serve metrics", log.Fields{"err": err})
+	}
+}
+
 func main() {
 	// fix for avoiding glog Noisy logs
 	_ = flag.CommandLine.Parse([]string{})
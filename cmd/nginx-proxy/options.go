@@ -8,14 +8,19 @@ import (
 
 // Options contains controller options
 type Options struct {
-	Kubeconfig string
-	Debug      bool
-	Cfg        config.Configuration
+	Kubeconfig      string
+	Debug           bool
+	TrafficProvider string
+	MetricsAddress  string
+	Cfg             config.Configuration
 }
 
 // NewOptions reutrns a new Options
 func NewOptions() *Options {
-	return &Options{}
+	return &Options{
+		TrafficProvider: providerNginx,
+		MetricsAddress:  ":9145",
+	}
 }
 
 // AddFlags add flags to app
@@ -28,6 +33,20 @@ func (opts *Options) AddFlags(app *cli.App) {
 			Usage:       "Path to a kube config. Only required if out-of-cluster.",
 			Destination: &opts.Kubeconfig,
 		},
+		cli.StringFlag{
+			Name:        "traffic-provider",
+			Usage:       "The data plane to split traffic, one of: nginx, l4",
+			EnvVar:      "TRAFFIC_PROVIDER",
+			Value:       opts.TrafficProvider,
+			Destination: &opts.TrafficProvider,
+		},
+		cli.StringFlag{
+			Name:        "metrics-address",
+			Usage:       "The address to expose Prometheus metrics of each canary side, empty means disabled",
+			EnvVar:      "METRICS_ADDRESS",
+			Value:       opts.MetricsAddress,
+			Destination: &opts.MetricsAddress,
+		},
 		cli.BoolFlag{
 			Name:        "debug",
 			Usage:       "Run with debug mode",
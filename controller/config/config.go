@@ -11,12 +11,21 @@ const (
 )
 
 type Configuration struct {
-	Client kubernetes.Interface
-	Proxy  Proxy
+	Client   kubernetes.Interface
+	Proxy    Proxy
+	Analysis Analysis
 }
 
 type Proxy struct {
 	Image string
+	// ConfigMap is the default ConfigMap of proxies in namespace/name format
+	ConfigMap string
+}
+
+// Analysis is the configuration of canary analysis
+type Analysis struct {
+	// PrometheusAddress is the default Prometheus compatible server of metrics
+	PrometheusAddress string
 }
 
 // AddFlags add flags to app
@@ -30,6 +39,18 @@ func (c *Configuration) AddFlags(app *cli.App) {
 			Value:       defaultProxy,
 			Destination: &c.Proxy.Image,
 		},
+		cli.StringFlag{
+			Name:        "proxy-configmap",
+			Usage:       "default `ConfigMap` of proxy in namespace/name format, used when canary release doesn't set one",
+			EnvVar:      "PROXY_CONFIGMAP",
+			Destination: &c.Proxy.ConfigMap,
+		},
+		cli.StringFlag{
+			Name:        "prometheus-address",
+			Usage:       "default `Address` of Prometheus compatible server for canary analysis, like http://prometheus:9090",
+			EnvVar:      "PROMETHEUS_ADDRESS",
+			Destination: &c.Analysis.PrometheusAddress,
+		},
 	}
 
 	app.Flags = append(app.Flags, flags...)
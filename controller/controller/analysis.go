@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/caicloud/canary-release/pkg/analysis"
+	"github.com/caicloud/canary-release/pkg/api"
+	"github.com/caicloud/canary-release/pkg/util"
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+	log "github.com/zoumo/logdog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// defaultAnalysisInterval is the interval between analyses if it's not set in strategy
+const defaultAnalysisInterval = 60 * time.Second
+
+// syncAnalysis evaluates the metrics of the strategy if the last analysis is
+// older than the interval or the current step. It returns true if all metrics
+// of the last analysis passed, a failed metric deprecates the canary release.
+func (crc *CanaryReleaseController) syncAnalysis(cr *releaseapi.CanaryRelease, now time.Time) (bool, error) {
+	spec := cr.Spec.Strategy.Analysis
+	if spec == nil || len(spec.Metrics) == 0 {
+		return true, nil
+	}
+
+	interval := defaultAnalysisInterval
+	if spec.IntervalSeconds > 0 {
+		interval = time.Duration(spec.IntervalSeconds) * time.Second
+	}
+	last := cr.Status.Analysis
+	if last != nil && analysisUpToDate(cr, last, now, interval) {
+		crc.queue.EnqueueAfter(cr, last.LastEvaluationTime.Add(interval).Sub(now))
+		return analysisPassed(last), nil
+	}
+
+	status := &releaseapi.CanaryAnalysisStatus{LastEvaluationTime: metav1.NewTime(now)}
+	var failed, errored *releaseapi.CanaryMetricStatus
+	for _, metric := range spec.Metrics {
+		result := crc.evaluateMetric(metric)
+		status.Metrics = append(status.Metrics, result)
+		if result.Phase == releaseapi.CanaryMetricFailed && failed == nil {
+			failed = &result
+		}
+		if result.Phase == releaseapi.CanaryMetricError && errored == nil {
+			errored = &result
+		}
+	}
+
+	_, err := util.UpdateCRWithRetries(
+		crc.client.ReleaseV1alpha1().CanaryReleases(cr.Namespace),
+		crc.crLister,
+		cr.Namespace,
+		cr.Name,
+		func(cr *releaseapi.CanaryRelease) error {
+			cr.Status.Analysis = status
+			if failed != nil {
+				cr.Status.Conditions = append(cr.Status.Conditions, api.NewCondition(api.ReasonAnalysisFailed,
+					fmt.Sprintf("metric %v failed: %v", failed.Name, failed.Message)))
+				return nil
+			}
+			// steps wait until the metric can be evaluated
+			if errored != nil {
+				message := fmt.Sprintf("metric %v can't be evaluated: %v", errored.Name, errored.Message)
+				if !api.IsLastCondition(cr, api.ReasonAnalysisError, message) {
+					cr.Status.Conditions = append(cr.Status.Conditions, api.NewCondition(api.ReasonAnalysisError, message))
+				}
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	if failed != nil {
+		log.Warn("Analysis failed, deprecate this CanaryRelease", log.Fields{"cr.name": cr.Name, "cr.ns": cr.Namespace, "metric": failed.Name, "message": failed.Message})
+		return false, crc.deprecate(cr)
+	}
+	crc.queue.EnqueueAfter(cr, interval)
+	return analysisPassed(status), nil
+}
+
+// analysisUpToDate returns true if the analysis is evaluated in the interval
+// and in the current step
+func analysisUpToDate(cr *releaseapi.CanaryRelease, status *releaseapi.CanaryAnalysisStatus, now time.Time, interval time.Duration) bool {
+	if !now.Before(status.LastEvaluationTime.Add(interval)) {
+		return false
+	}
+	if cr.Status.Strategy != nil && status.LastEvaluationTime.Before(&cr.Status.Strategy.StepStartTime) {
+		return false
+	}
+	return true
+}
+
+// analysisPassed returns true if all metrics passed
+func analysisPassed(status *releaseapi.CanaryAnalysisStatus) bool {
+	for _, m := range status.Metrics {
+		if m.Phase != releaseapi.CanaryMetricPassed {
+			return false
+		}
+	}
+	return true
+}
+
+// evaluateMetric queries the metric and checks whether the value is in range
+func (crc *CanaryReleaseController) evaluateMetric(metric releaseapi.CanaryMetric) releaseapi.CanaryMetricStatus {
+	status := releaseapi.CanaryMetricStatus{Name: metric.Name}
+	address := metric.Address
+	if address == "" {
+		address = crc.prometheusAddress
+	}
+	if address == "" {
+		status.Phase = releaseapi.CanaryMetricError
+		status.Message = "no Prometheus address is set"
+		return status
+	}
+
+	value, err := analysis.NewPrometheus(address).Query(metric.Query)
+	if err != nil {
+		status.Phase = releaseapi.CanaryMetricError
+		status.Message = err.Error()
+		return status
+	}
+	status.Value = strconv.FormatFloat(value, 'g', -1, 64)
+	return checkRange(status, value, metric.Min, metric.Max)
+}
+
+// checkRange sets the phase of the metric by the range, NaN and infinite
+// values can't be compared and are errors
+func checkRange(status releaseapi.CanaryMetricStatus, value float64, min, max *float64) releaseapi.CanaryMetricStatus {
+	switch {
+	case math.IsNaN(value) || math.IsInf(value, 0):
+		status.Phase = releaseapi.CanaryMetricError
+		status.Message = fmt.Sprintf("value %v is not a number in range", value)
+	case min != nil && value < *min:
+		status.Phase = releaseapi.CanaryMetricFailed
+		status.Message = fmt.Sprintf("value %v is less than %v", value, *min)
+	case max != nil && value > *max:
+		status.Phase = releaseapi.CanaryMetricFailed
+		status.Message = fmt.Sprintf("value %v is greater than %v", value, *max)
+	default:
+		status.Phase = releaseapi.CanaryMetricPassed
+	}
+	return status
+}
@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+)
+
+func TestEvaluateMetric(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("query") {
+		case "error_rate":
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1546300800,"0.05"]}]}}`)
+		case "no_traffic":
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"scalar","result":[1546300800,"NaN"]}}`)
+		case "overflow":
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"scalar","result":[1546300800,"+Inf"]}}`)
+		default:
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+		}
+	}))
+	defer server.Close()
+
+	float := func(f float64) *float64 { return &f }
+	tests := []struct {
+		name   string
+		metric releaseapi.CanaryMetric
+		phase  releaseapi.CanaryMetricPhase
+	}{
+		{"in range", releaseapi.CanaryMetric{Query: "error_rate", Max: float(0.1)}, releaseapi.CanaryMetricPassed},
+		{"greater than max", releaseapi.CanaryMetric{Query: "error_rate", Max: float(0.01)}, releaseapi.CanaryMetricFailed},
+		{"less than min", releaseapi.CanaryMetric{Query: "error_rate", Min: float(0.5)}, releaseapi.CanaryMetricFailed},
+		{"NaN", releaseapi.CanaryMetric{Query: "no_traffic", Max: float(0.1)}, releaseapi.CanaryMetricError},
+		{"infinite", releaseapi.CanaryMetric{Query: "overflow", Min: float(0.1)}, releaseapi.CanaryMetricError},
+		{"no samples", releaseapi.CanaryMetric{Query: "no_samples", Max: float(0.1)}, releaseapi.CanaryMetricError},
+		{"unreachable", releaseapi.CanaryMetric{Query: "error_rate", Address: "http://127.0.0.1:1"}, releaseapi.CanaryMetricError},
+	}
+	crc := &CanaryReleaseController{prometheusAddress: server.URL}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := crc.evaluateMetric(tt.metric)
+			if got.Phase != tt.phase {
+				t.Errorf("evaluateMetric() = %+v, want phase %v", got, tt.phase)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -27,13 +28,23 @@ import (
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	appsv1 "k8s.io/client-go/listers/apps/v1"
 	corev1 "k8s.io/client-go/listers/core/v1"
+	policyv1beta1 "k8s.io/client-go/listers/policy/v1beta1"
 	"k8s.io/client-go/tools/cache"
 )
 
 const (
 	proxyNameSuffix = "-proxy"
+	// proxyMetricsPort is the default port of proxy to expose Prometheus metrics
+	proxyMetricsPort = 9145
+	// proxyConfigMapEnv is the env of proxy to set its configmap
+	proxyConfigMapEnv = "PROXY_CONFIGMAP"
+	// proxyLeaderElectEnv is the env of proxy to enable leader election
+	proxyLeaderElectEnv = "LEADER_ELECT"
 )
 
+// reconciledProxyEnvs are envs of proxy updated on existing proxies
+var reconciledProxyEnvs = []string{proxyConfigMapEnv, proxyLeaderElectEnv}
+
 var (
 	// controllerKind contains the schema.GroupVersionKind for this controller type.
 	controllerKind = releaseapi.SchemeGroupVersion.WithKind(api.CanaryReleaseKind)
@@ -41,7 +52,9 @@ var (
 
 // CanaryReleaseController ...
 type CanaryReleaseController struct {
-	proxyImage string
+	proxyImage        string
+	proxyConfigMap    string
+	prometheusAddress string
 
 	client kubernetes.Interface
 	// crdclient apiextensionsclient.Interface
@@ -51,6 +64,7 @@ type CanaryReleaseController struct {
 	rLister   releaselisters.ReleaseLister
 	dLister   appsv1.DeploymentLister
 	podLister corev1.PodLister
+	pdbLister policyv1beta1.PodDisruptionBudgetLister
 
 	queue *syncqueue.SyncQueue
 }
@@ -63,15 +77,19 @@ func NewCanaryReleaseController(cfg config.Configuration) *CanaryReleaseControll
 	rinformer := factory.Release().V1alpha1().Releases()
 	dinformer := factory.Apps().V1().Deployments()
 	podinformer := factory.Core().V1().Pods()
+	pdbinformer := factory.Policy().V1beta1().PodDisruptionBudgets()
 
 	crc := &CanaryReleaseController{
-		proxyImage: cfg.Proxy.Image,
-		client:     cfg.Client,
-		factory:    factory,
-		crLister:   crinformer.Lister(),
-		rLister:    rinformer.Lister(),
-		dLister:    dinformer.Lister(),
-		podLister:  podinformer.Lister(),
+		proxyImage:        cfg.Proxy.Image,
+		proxyConfigMap:    cfg.Proxy.ConfigMap,
+		prometheusAddress: cfg.Analysis.PrometheusAddress,
+		client:            cfg.Client,
+		factory:           factory,
+		crLister:          crinformer.Lister(),
+		rLister:           rinformer.Lister(),
+		dLister:           dinformer.Lister(),
+		podLister:         podinformer.Lister(),
+		pdbLister:         pdbinformer.Lister(),
 	}
 	crc.queue = syncqueue.NewPassthroughSyncQueue(&releaseapi.CanaryRelease{}, crc.syncCanaryRelease)
 	crinformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -233,6 +251,21 @@ func (crc *CanaryReleaseController) syncCanaryRelease(obj interface{}) error {
 		return crc.deprecate(cr)
 	}
 
+	if deprecated, err := crc.syncDeadline(cr); deprecated || err != nil {
+		return err
+	}
+
+	cr, err = crc.syncPause(cr)
+	if err != nil {
+		log.Error("Error sync pause of CanaryRelease", log.Fields{"cr": key, "err": err})
+		return err
+	}
+
+	if err := crc.syncStrategy(cr); err != nil {
+		log.Error("Error sync strategy of CanaryRelease", log.Fields{"cr": key, "err": err})
+		return err
+	}
+
 	ds, err := crc.getDeploymentsForCanaryRelease(cr)
 	if err != nil {
 		return err
@@ -296,7 +329,29 @@ func (crc *CanaryReleaseController) sync(cr *releaseapi.CanaryRelease, dps []*ap
 
 		updated = true
 		activeDeploy = dp
-		// no need to update, let proxy do it
+		// only replicas, affinity and some envs are updated, let proxy do others.
+		// proxies created by older controllers get the envs here.
+		envChanged := false
+		for _, env := range reconciledProxyEnvs {
+			if proxyEnv(dp, env) != proxyEnv(desiredDeploy, env) {
+				envChanged = true
+			}
+		}
+		if *dp.Spec.Replicas != *desiredDeploy.Spec.Replicas ||
+			!reflect.DeepEqual(dp.Spec.Template.Spec.Affinity, desiredDeploy.Spec.Template.Spec.Affinity) ||
+			envChanged {
+			log.Info("Update proxy for canary release", log.Fields{"dp.name": dp.Name, "cr.name": cr.Name, "replicas": *desiredDeploy.Spec.Replicas})
+			dp = dp.DeepCopy()
+			dp.Spec.Replicas = desiredDeploy.Spec.Replicas
+			dp.Spec.Template.Spec.Affinity = desiredDeploy.Spec.Template.Spec.Affinity
+			for _, env := range reconciledProxyEnvs {
+				setProxyEnv(dp, env, proxyEnv(desiredDeploy, env))
+			}
+			activeDeploy, err = crc.client.AppsV1().Deployments(dp.Namespace).Update(dp)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	if !updated {
@@ -308,6 +363,11 @@ func (crc *CanaryReleaseController) sync(cr *releaseapi.CanaryRelease, dps []*ap
 		}
 	}
 
+	if err = crc.syncPodDisruptionBudget(cr); err != nil {
+		log.Error("Error sync proxy pod disruption budget", log.Fields{"cr.name": cr.Name, "err": err})
+		return err
+	}
+
 	return crc.syncStatus(cr, activeDeploy)
 }
 
@@ -432,7 +492,7 @@ func (crc *CanaryReleaseController) generateDeployment(cr *releaseapi.CanaryRele
 	terminationGraPeridSeconds := int64(60)
 	labels := crc.selector(cr)
 	t := true
-	replicas := int32(1)
+	replicas := proxyReplicas(cr)
 	deploy := &apps.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:   cr.Name + proxyNameSuffix,
@@ -456,14 +516,26 @@ func (crc *CanaryReleaseController) generateDeployment(cr *releaseapi.CanaryRele
 			Template: core.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
 					Labels: labels,
+					Annotations: map[string]string{
+						"prometheus.io/scrape": "true",
+						"prometheus.io/port":   strconv.Itoa(proxyMetricsPort),
+					},
 				},
 				Spec: core.PodSpec{
 					TerminationGracePeriodSeconds: &terminationGraPeridSeconds,
+					Affinity:                      proxyAffinity(labels),
 					Containers: []core.Container{
 						{
 							Name:      "canary-release-proxy",
 							Image:     crc.proxyImage,
 							Resources: cr.Spec.Resources,
+							Ports: []core.ContainerPort{
+								{
+									Name:          "metrics",
+									ContainerPort: proxyMetricsPort,
+									Protocol:      core.ProtocolTCP,
+								},
+							},
 							Env: []core.EnvVar{
 								{
 									Name:  "CANARY_RELEASE_NAME",
@@ -477,6 +549,14 @@ func (crc *CanaryReleaseController) generateDeployment(cr *releaseapi.CanaryRele
 									Name:  "RELEASE_NAME",
 									Value: cr.Spec.Release,
 								},
+								{
+									Name: "POD_NAME",
+									ValueFrom: &core.EnvVarSource{
+										FieldRef: &core.ObjectFieldSelector{
+											FieldPath: "metadata.name",
+										},
+									},
+								},
 							},
 						},
 					},
@@ -485,9 +565,65 @@ func (crc *CanaryReleaseController) generateDeployment(cr *releaseapi.CanaryRele
 		},
 	}
 
+	// replicas of proxy must not change resources in cluster at the same time
+	if proxyReplicas(cr) > 1 {
+		container := &deploy.Spec.Template.Spec.Containers[0]
+		container.Env = append(container.Env, core.EnvVar{
+			Name:  proxyLeaderElectEnv,
+			Value: "true",
+		})
+	}
+
+	if cm := crc.configMapOf(cr); cm != "" {
+		container := &deploy.Spec.Template.Spec.Containers[0]
+		container.Env = append(container.Env, core.EnvVar{
+			Name:  proxyConfigMapEnv,
+			Value: cm,
+		})
+	}
+
 	return deploy
 }
 
+// configMapOf returns the configmap tuning the proxy of the canary release in
+// namespace/name format, it's empty if there is no configmap
+func (crc *CanaryReleaseController) configMapOf(cr *releaseapi.CanaryRelease) string {
+	if cr.Spec.ConfigMap != "" {
+		return cr.Namespace + "/" + cr.Spec.ConfigMap
+	}
+	return crc.proxyConfigMap
+}
+
+// proxyEnv returns the value of the env of proxy container in deployment
+func proxyEnv(dp *apps.Deployment, name string) string {
+	for _, c := range dp.Spec.Template.Spec.Containers {
+		for _, env := range c.Env {
+			if env.Name == name {
+				return env.Value
+			}
+		}
+	}
+	return ""
+}
+
+// setProxyEnv sets the env of proxy containers in deployment, the env is
+// removed if value is empty
+func setProxyEnv(dp *apps.Deployment, name, value string) {
+	for i := range dp.Spec.Template.Spec.Containers {
+		c := &dp.Spec.Template.Spec.Containers[i]
+		env := c.Env[:0]
+		for _, e := range c.Env {
+			if e.Name != name {
+				env = append(env, e)
+			}
+		}
+		if value != "" {
+			env = append(env, core.EnvVar{Name: name, Value: value})
+		}
+		c.Env = env
+	}
+}
+
 // RecheckDeletionTimestamp returns a canAdopt() function to recheck deletion.
 //
 // The canAdopt() function calls getObject() to fetch the latest value,
@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"testing"
+
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+)
+
+func TestSetProxyEnv(t *testing.T) {
+	newDeploy := func(env ...core.EnvVar) *apps.Deployment {
+		dp := &apps.Deployment{}
+		dp.Spec.Template.Spec.Containers = []core.Container{{Name: "canary-release-proxy", Env: env}}
+		return dp
+	}
+	name := core.EnvVar{Name: "CANARY_RELEASE_NAME", Value: "cr"}
+
+	tests := []struct {
+		name  string
+		dp    *apps.Deployment
+		value string
+	}{
+		{"add to old proxy", newDeploy(name), "default/cm"},
+		{"replace", newDeploy(name, core.EnvVar{Name: proxyConfigMapEnv, Value: "default/old"}), "default/cm"},
+		{"remove", newDeploy(name, core.EnvVar{Name: proxyConfigMapEnv, Value: "default/old"}), ""},
+		{"keep empty", newDeploy(name), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setProxyEnv(tt.dp, proxyConfigMapEnv, tt.value)
+			if got := proxyEnv(tt.dp, proxyConfigMapEnv); got != tt.value {
+				t.Errorf("proxyEnv() = %q, want %q", got, tt.value)
+			}
+			if got := proxyEnv(tt.dp, name.Name); got != name.Value {
+				t.Errorf("proxyEnv(%v) = %q, want %q", name.Name, got, name.Value)
+			}
+			if env := tt.dp.Spec.Template.Spec.Containers[0].Env; len(env) > 2 {
+				t.Errorf("env = %v, want at most one %v", env, proxyConfigMapEnv)
+			}
+		})
+	}
+}
+
+func TestGenerateDeploymentLeaderElect(t *testing.T) {
+	replicas := func(r int32) *int32 {
+		return &r
+	}
+	tests := []struct {
+		name     string
+		replicas *int32
+		want     string
+	}{
+		{"default", nil, ""},
+		{"one proxy", replicas(1), ""},
+		{"two proxies", replicas(2), "true"},
+	}
+	crc := &CanaryReleaseController{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := &releaseapi.CanaryRelease{}
+			cr.Name = "web"
+			cr.Spec.ProxyReplicas = tt.replicas
+			if got := proxyEnv(crc.generateDeployment(cr), proxyLeaderElectEnv); got != tt.want {
+				t.Errorf("%v = %q, want %q", proxyLeaderElectEnv, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/caicloud/canary-release/pkg/api"
+	"github.com/caicloud/canary-release/pkg/util"
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+	log "github.com/zoumo/logdog"
+)
+
+// syncDeadline deprecates the canary release if it's not adopted by the
+// deadline or not available by the progress deadline, and returns true if
+// it's deprecated. Otherwise it's enqueued again at the next deadline.
+func (crc *CanaryReleaseController) syncDeadline(cr *releaseapi.CanaryRelease) (bool, error) {
+	if cr.Spec.Transition != releaseapi.CanaryTrasitionNone {
+		return false, nil
+	}
+
+	reason, message, next := deadlineExceeded(cr, time.Now())
+	if reason == "" {
+		if next > 0 {
+			crc.queue.EnqueueAfter(cr, next)
+		}
+		return false, nil
+	}
+
+	log.Warn("Deadline exceeded, deprecate this CanaryRelease", log.Fields{"cr.name": cr.Name, "cr.ns": cr.Namespace, "reason": reason})
+	_, err := util.UpdateCRWithRetries(
+		crc.client.ReleaseV1alpha1().CanaryReleases(cr.Namespace),
+		crc.crLister,
+		cr.Namespace,
+		cr.Name,
+		func(cr *releaseapi.CanaryRelease) error {
+			cr.Status.Conditions = append(cr.Status.Conditions, api.NewCondition(reason, message))
+			return nil
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	return true, crc.deprecate(cr)
+}
+
+// deadlineExceeded returns the reason and message if a deadline is exceeded
+// at now, or how long it's until the next deadline, zero if there is none
+func deadlineExceeded(cr *releaseapi.CanaryRelease, now time.Time) (string, string, time.Duration) {
+	created := cr.CreationTimestamp.Time
+	var next time.Duration
+	check := func(seconds *int32) bool {
+		if seconds == nil {
+			return false
+		}
+		left := created.Add(time.Duration(*seconds) * time.Second).Sub(now)
+		if left <= 0 {
+			return true
+		}
+		if next == 0 || left < next {
+			next = left
+		}
+		return false
+	}
+
+	if check(cr.Spec.DeadlineSeconds) {
+		return api.ReasonDeadlineExceeded,
+			fmt.Sprintf("not adopted in %d seconds", *cr.Spec.DeadlineSeconds), 0
+	}
+	if !everAvailable(cr) && check(cr.Spec.ProgressDeadlineSeconds) {
+		return api.ReasonProgressDeadlineExceeded,
+			fmt.Sprintf("not available in %d seconds", *cr.Spec.ProgressDeadlineSeconds), 0
+	}
+	return "", "", next
+}
+
+// everAvailable returns true if the proxy has synced the canary release successfully
+func everAvailable(cr *releaseapi.CanaryRelease) bool {
+	for _, c := range cr.Status.Conditions {
+		if c.Reason == api.ReasonAvailable {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/caicloud/canary-release/pkg/api"
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDeadlineExceeded(t *testing.T) {
+	created := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	seconds := func(s int32) *int32 {
+		return &s
+	}
+	available := []releaseapi.CanaryReleaseCondition{
+		api.NewCondition(api.ReasonUpdating, ""),
+		api.NewCondition(api.ReasonAvailable, ""),
+	}
+
+	tests := []struct {
+		name       string
+		deadline   *int32
+		progress   *int32
+		conditions []releaseapi.CanaryReleaseCondition
+		now        time.Duration
+		reason     string
+		next       time.Duration
+	}{
+		{"no deadlines", nil, nil, nil, time.Hour, "", 0},
+		{"before deadline", seconds(3600), nil, nil, 10 * time.Minute, "", 50 * time.Minute},
+		{"deadline exceeded", seconds(3600), nil, available, time.Hour, api.ReasonDeadlineExceeded, 0},
+		{"before progress deadline", seconds(3600), seconds(600), nil, time.Minute, "", 9 * time.Minute},
+		{"progress deadline exceeded", seconds(3600), seconds(600), nil, 10 * time.Minute, api.ReasonProgressDeadlineExceeded, 0},
+		{"available in time", seconds(3600), seconds(600), available, 20 * time.Minute, "", 40 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := &releaseapi.CanaryRelease{
+				ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(created)},
+				Spec:       releaseapi.CanaryReleaseSpec{DeadlineSeconds: tt.deadline, ProgressDeadlineSeconds: tt.progress},
+				Status:     releaseapi.CanaryReleaseStatus{Conditions: tt.conditions},
+			}
+			reason, _, next := deadlineExceeded(cr, created.Add(tt.now))
+			if reason != tt.reason || next != tt.next {
+				t.Errorf("deadlineExceeded() = %q, %v, want %q, %v", reason, next, tt.reason, tt.next)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"reflect"
+
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+	log "github.com/zoumo/logdog"
+	core "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// defaultProxyReplicas is the number of proxies if it's not set in canary release
+	defaultProxyReplicas = int32(1)
+	// proxyTopologyKey is the topology proxies of a canary release are spread over
+	proxyTopologyKey = "kubernetes.io/hostname"
+)
+
+// proxyReplicas returns the desired number of proxies of the canary release
+func proxyReplicas(cr *releaseapi.CanaryRelease) int32 {
+	if cr.Spec.ProxyReplicas == nil || *cr.Spec.ProxyReplicas < 1 {
+		return defaultProxyReplicas
+	}
+	return *cr.Spec.ProxyReplicas
+}
+
+// proxyAffinity prefers to schedule proxies of the same canary release to
+// different nodes, one node failure will not take down all of them
+func proxyAffinity(selector labels.Set) *core.Affinity {
+	return &core.Affinity{
+		PodAntiAffinity: &core.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []core.WeightedPodAffinityTerm{
+				{
+					Weight: 100,
+					PodAffinityTerm: core.PodAffinityTerm{
+						LabelSelector: &metav1.LabelSelector{
+							MatchLabels: selector,
+						},
+						TopologyKey: proxyTopologyKey,
+					},
+				},
+			},
+		},
+	}
+}
+
+// generatePodDisruptionBudget keeps at least one proxy available during
+// voluntary disruptions. It returns nil for a single proxy, a budget for
+// it would block draining the node forever.
+func (crc *CanaryReleaseController) generatePodDisruptionBudget(cr *releaseapi.CanaryRelease) *policy.PodDisruptionBudget {
+	if proxyReplicas(cr) <= 1 {
+		return nil
+	}
+	selector := crc.selector(cr)
+	t := true
+	minAvailable := intstr.FromInt(1)
+	return &policy.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   cr.Name + proxyNameSuffix,
+			Labels: selector,
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion:         controllerKind.GroupVersion().String(),
+					Kind:               controllerKind.Kind,
+					Name:               cr.Name,
+					UID:                cr.UID,
+					Controller:         &t,
+					BlockOwnerDeletion: &t,
+				},
+			},
+		},
+		Spec: policy.PodDisruptionBudgetSpec{
+			MinAvailable: &minAvailable,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: selector,
+			},
+		},
+	}
+}
+
+// syncPodDisruptionBudget reconciles the pod disruption budget of proxies with
+// the replicas, it's deleted with the canary release by the garbage collector
+func (crc *CanaryReleaseController) syncPodDisruptionBudget(cr *releaseapi.CanaryRelease) error {
+	name := cr.Name + proxyNameSuffix
+	desired := crc.generatePodDisruptionBudget(cr)
+	pdb, err := crc.pdbLister.PodDisruptionBudgets(cr.Namespace).Get(name)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	if pdb != nil {
+		if desired != nil && reflect.DeepEqual(pdb.Spec, desired.Spec) {
+			return nil
+		}
+		// spec of pod disruption budget is immutable before kubernetes 1.15,
+		// so it's recreated
+		log.Info("Delete proxy pod disruption budget for canary release", log.Fields{"pdb.name": name, "cr.name": cr.Name})
+		err = crc.client.PolicyV1beta1().PodDisruptionBudgets(cr.Namespace).Delete(name, &metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	if desired == nil {
+		return nil
+	}
+
+	log.Info("Create proxy pod disruption budget for canary release", log.Fields{"pdb.name": name, "cr.name": cr.Name})
+	_, err = crc.client.PolicyV1beta1().PodDisruptionBudgets(cr.Namespace).Create(desired)
+	if pdb == nil && errors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,41 @@
+package controller
+
+import (
+	"testing"
+
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+)
+
+func TestGeneratePodDisruptionBudget(t *testing.T) {
+	replicas := func(r int32) *int32 {
+		return &r
+	}
+	tests := []struct {
+		name     string
+		replicas *int32
+		want     bool
+	}{
+		{"default", nil, false},
+		{"one proxy", replicas(1), false},
+		{"two proxies", replicas(2), true},
+		{"three proxies", replicas(3), true},
+	}
+	crc := &CanaryReleaseController{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := &releaseapi.CanaryRelease{}
+			cr.Name = "web"
+			cr.Spec.ProxyReplicas = tt.replicas
+			pdb := crc.generatePodDisruptionBudget(cr)
+			if (pdb != nil) != tt.want {
+				t.Fatalf("generatePodDisruptionBudget() = %v, want budget %v", pdb, tt.want)
+			}
+			if pdb == nil {
+				return
+			}
+			if pdb.Spec.MaxUnavailable != nil || pdb.Spec.MinAvailable == nil || pdb.Spec.MinAvailable.IntValue() != 1 {
+				t.Errorf("generatePodDisruptionBudget() spec = %+v, want minAvailable 1", pdb.Spec)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/caicloud/canary-release/pkg/api"
+	"github.com/caicloud/canary-release/pkg/gate"
+	"github.com/caicloud/canary-release/pkg/util"
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+	log "github.com/zoumo/logdog"
+)
+
+// stepGated returns true if the step raises the weight of canary and a gate
+// rejects it. The rejection pauses the canary release with a condition, it
+// stays in the current step and gates are called again after it's resumed.
+func (crc *CanaryReleaseController) stepGated(cr *releaseapi.CanaryRelease, step int32, weight int32) (bool, error) {
+	if !gate.Has(cr.Spec.Gates, releaseapi.CanaryGateStep) || !raisesWeight(cr, weight) {
+		return false, nil
+	}
+	payload := gate.NewPayload(cr, releaseapi.CanaryGateStep)
+	payload.Step = &step
+	payload.Weight = &weight
+	err := gate.Check(cr.Spec.Gates, payload)
+	if err == nil {
+		return false, nil
+	}
+
+	log.Warn("Step is rejected by gate, pause it", log.Fields{"cr.name": cr.Name, "cr.ns": cr.Namespace, "step": step, "err": err})
+	message := fmt.Sprintf("step %d: %v", step+1, err)
+	_, err = util.UpdateCRWithRetries(
+		crc.client.ReleaseV1alpha1().CanaryReleases(cr.Namespace),
+		crc.crLister,
+		cr.Namespace,
+		cr.Name,
+		func(cr *releaseapi.CanaryRelease) error {
+			cr.Spec.Paused = true
+			cr.Status.Conditions = append(cr.Status.Conditions, api.NewCondition(api.ReasonGateRejected, message))
+			return nil
+		},
+	)
+	return true, err
+}
+
+// raisesWeight returns true if the weight is higher than the weight of any port
+func raisesWeight(cr *releaseapi.CanaryRelease, weight int32) bool {
+	for _, svc := range cr.Spec.Service {
+		for _, port := range svc.Ports {
+			if port.Config.Weight == nil || *port.Config.Weight < weight {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/caicloud/canary-release/pkg/api"
+	"github.com/caicloud/canary-release/pkg/util"
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+	log "github.com/zoumo/logdog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// syncPause records when the canary release is paused and resumed, and
+// returns the updated canary release. The current step of strategy is
+// extended by the pause, so it keeps the rest of its pause after resuming.
+func (crc *CanaryReleaseController) syncPause(cr *releaseapi.CanaryRelease) (*releaseapi.CanaryRelease, error) {
+	if cr.Spec.Paused == (cr.Status.PausedTime != nil) && cr.Spec.Paused == (cr.Status.PausedSpec != nil) {
+		return cr, nil
+	}
+
+	log.Info("Pause or resume CanaryRelease", log.Fields{"cr.name": cr.Name, "cr.ns": cr.Namespace, "paused": cr.Spec.Paused})
+	now := metav1.NewTime(time.Now().Truncate(time.Second))
+	return util.UpdateCRWithRetries(
+		crc.client.ReleaseV1alpha1().CanaryReleases(cr.Namespace),
+		crc.crLister,
+		cr.Namespace,
+		cr.Name,
+		func(cr *releaseapi.CanaryRelease) error {
+			switch {
+			case cr.Spec.Paused && cr.Status.PausedTime == nil:
+				cr.Status.PausedTime = &now
+				cr.Status.Conditions = append(cr.Status.Conditions, api.NewCondition(api.ReasonPaused, ""))
+			case !cr.Spec.Paused && cr.Status.PausedTime != nil:
+				resumeStrategy(cr.Status.Strategy, now.Sub(cr.Status.PausedTime.Time))
+				cr.Status.PausedTime = nil
+				cr.Status.Conditions = append(cr.Status.Conditions, api.NewCondition(api.ReasonResumed, ""))
+			}
+			// proxies sync a paused canary release with the frozen spec,
+			// so it survives restarts of proxies
+			cr.Status.PausedSpec = nil
+			if cr.Spec.Paused {
+				cr.Status.PausedSpec = pausedSpec(cr)
+			}
+			return nil
+		},
+	)
+}
+
+// pausedSpec returns the spec to keep while the canary release is paused,
+// the one frozen before is kept
+func pausedSpec(cr *releaseapi.CanaryRelease) *releaseapi.CanaryReleaseSpec {
+	if cr.Status.PausedSpec != nil {
+		return cr.Status.PausedSpec
+	}
+	return cr.Spec.DeepCopy()
+}
+
+// resumeStrategy extends the current step by the pause
+func resumeStrategy(status *releaseapi.CanaryStrategyStatus, pause time.Duration) {
+	if status == nil {
+		return
+	}
+	status.StepStartTime = metav1.NewTime(status.StepStartTime.Add(pause))
+	if status.NextStepTime != nil {
+		next := metav1.NewTime(status.NextStepTime.Add(pause))
+		status.NextStepTime = &next
+	}
+}
@@ -159,6 +159,9 @@ func (crc *CanaryReleaseController) syncStatus(cr *releaseapi.CanaryRelease, act
 			cr.Namespace,
 			cr.Name,
 			func(cr *releaseapi.CanaryRelease) error {
+				if condition := proxyAvailability(cr, proxyStatus); condition != nil {
+					cr.Status.Conditions = append(cr.Status.Conditions, *condition)
+				}
 				cr.Status.Proxy = proxyStatus
 				statusErr := false
 				reason := ""
@@ -188,6 +191,28 @@ func (crc *CanaryReleaseController) syncStatus(cr *releaseapi.CanaryRelease, act
 	return nil
 }
 
+// proxyAvailability returns a Degraded condition when fewer proxies become
+// ready than desired, and an Available condition when they recover.
+// Proxies being created or scaled are not degraded.
+func proxyAvailability(cr *releaseapi.CanaryRelease, status releaseapi.CanaryReleaseProxyStatus) *releaseapi.CanaryReleaseCondition {
+	old := cr.Status.Proxy
+	wasAvailable := old.ReadyReplicas > 0 && old.ReadyReplicas >= old.Replicas && old.Replicas == status.Replicas
+	available := status.ReadyReplicas >= status.Replicas
+	degraded := len(cr.Status.Conditions) > 0 &&
+		cr.Status.Conditions[len(cr.Status.Conditions)-1].Reason == api.ReasonDegraded
+
+	switch {
+	case wasAvailable && !available && !degraded:
+		condition := api.NewCondition(api.ReasonDegraded,
+			fmt.Sprintf("%v of %v proxies are ready", status.ReadyReplicas, status.Replicas))
+		return &condition
+	case available && degraded:
+		condition := api.NewCondition(api.ReasonAvailable, "")
+		return &condition
+	}
+	return nil
+}
+
 // SplitNamespaceAndNameByDot returns the namespace and name that
 // encoded into the label or value by dot
 func SplitNamespaceAndNameByDot(value string) (namespace, name string, err error) {
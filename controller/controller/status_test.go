@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/caicloud/canary-release/pkg/api"
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+)
+
+func TestProxyAvailability(t *testing.T) {
+	proxy := func(replicas, ready int32) releaseapi.CanaryReleaseProxyStatus {
+		return releaseapi.CanaryReleaseProxyStatus{Replicas: replicas, ReadyReplicas: ready}
+	}
+	degraded := []releaseapi.CanaryReleaseCondition{api.NewCondition(api.ReasonDegraded, "")}
+
+	tests := []struct {
+		name       string
+		old        releaseapi.CanaryReleaseProxyStatus
+		conditions []releaseapi.CanaryReleaseCondition
+		status     releaseapi.CanaryReleaseProxyStatus
+		reason     string
+	}{
+		{"creating", proxy(0, 0), nil, proxy(2, 0), ""},
+		{"created", proxy(2, 0), nil, proxy(2, 2), ""},
+		{"available", proxy(2, 2), nil, proxy(2, 2), ""},
+		{"scaling", proxy(2, 2), nil, proxy(3, 2), ""},
+		{"degraded", proxy(2, 2), nil, proxy(2, 1), api.ReasonDegraded},
+		{"still degraded", proxy(2, 1), degraded, proxy(2, 1), ""},
+		{"degraded again", proxy(2, 2), degraded, proxy(2, 0), ""},
+		{"recovered", proxy(2, 1), degraded, proxy(2, 2), api.ReasonAvailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := &releaseapi.CanaryRelease{}
+			cr.Status.Proxy = tt.old
+			cr.Status.Conditions = tt.conditions
+			reason := ""
+			if condition := proxyAvailability(cr, tt.status); condition != nil {
+				reason = condition.Reason
+			}
+			if reason != tt.reason {
+				t.Errorf("proxyAvailability() = %q, want %q", reason, tt.reason)
+			}
+		})
+	}
+}
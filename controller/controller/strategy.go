@@ -0,0 +1,161 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/caicloud/canary-release/pkg/api"
+	"github.com/caicloud/canary-release/pkg/util"
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+	log "github.com/zoumo/logdog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// syncStrategy moves the weights of all ports through the steps of strategy,
+// and adopts the canary release after the last step if it's configured to.
+// The canary release is enqueued again when the next step starts.
+// Steps wait until the analysis passes and gates accept them, and a paused
+// canary release is neither analyzed nor stepped.
+func (crc *CanaryReleaseController) syncStrategy(cr *releaseapi.CanaryRelease) error {
+	strategy := cr.Spec.Strategy
+	if strategy == nil || cr.Spec.Transition != releaseapi.CanaryTrasitionNone || cr.Spec.Paused {
+		return nil
+	}
+
+	// the stored times are in seconds
+	now := time.Now().Truncate(time.Second)
+	passed, err := crc.syncAnalysis(cr, now)
+	if err != nil || !passed || len(strategy.Steps) == 0 {
+		return err
+	}
+
+	status, adopt := progress(strategy, cr.Status.Strategy, now)
+	step := strategy.Steps[status.CurrentStep]
+
+	if !strategyStatusEqual(cr.Status.Strategy, &status) || !weightsSet(cr, step.Weight) {
+		if gated, err := crc.stepGated(cr, status.CurrentStep, step.Weight); gated || err != nil {
+			return err
+		}
+		log.Info("Move canary release to step", log.Fields{"cr.name": cr.Name, "cr.ns": cr.Namespace, "step": status.CurrentStep, "weight": step.Weight})
+		stepped := cr.Status.Strategy == nil || cr.Status.Strategy.CurrentStep != status.CurrentStep
+		_, err := util.UpdateCRWithRetries(
+			crc.client.ReleaseV1alpha1().CanaryReleases(cr.Namespace),
+			crc.crLister,
+			cr.Namespace,
+			cr.Name,
+			func(cr *releaseapi.CanaryRelease) error {
+				setWeights(cr, step.Weight)
+				cr.Status.Strategy = &status
+				if stepped {
+					cr.Status.Conditions = append(cr.Status.Conditions, api.NewCondition(api.ReasonStepped,
+						fmt.Sprintf("step %d of %d, weight %d", status.CurrentStep+1, len(strategy.Steps), step.Weight)))
+				}
+				return nil
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if adopt {
+		log.Info("All steps finished, adopt this CanaryRelease", log.Fields{"cr.name": cr.Name, "cr.ns": cr.Namespace})
+		return crc.adopt(cr)
+	}
+	if status.NextStepTime != nil {
+		crc.queue.EnqueueAfter(cr, status.NextStepTime.Sub(now))
+	}
+	return nil
+}
+
+// progress returns the status of strategy at now, and whether the
+// canary release should be adopted. It moves at most one step forward,
+// the weight of every step is applied before the next one starts.
+func progress(strategy *releaseapi.CanaryStrategy, status *releaseapi.CanaryStrategyStatus, now time.Time) (releaseapi.CanaryStrategyStatus, bool) {
+	if status == nil || status.CurrentStep < 0 || int(status.CurrentStep) >= len(strategy.Steps) {
+		status = &releaseapi.CanaryStrategyStatus{
+			CurrentStep:   0,
+			StepStartTime: metav1.NewTime(now),
+		}
+	}
+	current := *status.DeepCopy()
+	current.NextStepTime = nextStepTime(strategy, &current)
+
+	if current.NextStepTime == nil || now.Before(current.NextStepTime.Time) {
+		return current, false
+	}
+	if int(current.CurrentStep) == len(strategy.Steps)-1 {
+		// the last step is finished
+		return current, true
+	}
+	current = releaseapi.CanaryStrategyStatus{
+		CurrentStep:   current.CurrentStep + 1,
+		StepStartTime: metav1.NewTime(now),
+	}
+	current.NextStepTime = nextStepTime(strategy, &current)
+	return current, false
+}
+
+// nextStepTime returns the end of the current step, it's nil if the current
+// step is the last one and the canary release is not adopted automatically
+func nextStepTime(strategy *releaseapi.CanaryStrategy, status *releaseapi.CanaryStrategyStatus) *metav1.Time {
+	if int(status.CurrentStep) == len(strategy.Steps)-1 && !strategy.AutoAdopt {
+		return nil
+	}
+	var pause time.Duration
+	if p := strategy.Steps[status.CurrentStep].Pause; p != nil {
+		pause = p.Duration
+	}
+	end := metav1.NewTime(status.StepStartTime.Add(pause))
+	return &end
+}
+
+// strategyStatusEqual compares the statuses in seconds, which are kept in the stored status
+func strategyStatusEqual(a, b *releaseapi.CanaryStrategyStatus) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	timeEqual := func(x, y *metav1.Time) bool {
+		if x == nil || y == nil {
+			return x == y
+		}
+		return x.Unix() == y.Unix()
+	}
+	return a.CurrentStep == b.CurrentStep &&
+		timeEqual(&a.StepStartTime, &b.StepStartTime) &&
+		timeEqual(a.NextStepTime, b.NextStepTime)
+}
+
+// weightsSet returns true if all ports use the weight
+func weightsSet(cr *releaseapi.CanaryRelease, weight int32) bool {
+	for _, svc := range cr.Spec.Service {
+		for _, port := range svc.Ports {
+			if port.Config.Weight == nil || *port.Config.Weight != weight {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// setWeights sets the weight to all ports
+func setWeights(cr *releaseapi.CanaryRelease, weight int32) {
+	for i := range cr.Spec.Service {
+		ports := cr.Spec.Service[i].Ports
+		for j := range ports {
+			w := weight
+			ports[j].Config.Weight = &w
+		}
+	}
+}
+
+// adopt changes the canary release's transition to Adopted
+func (crc *CanaryReleaseController) adopt(cr *releaseapi.CanaryRelease) error {
+	if cr.Spec.Transition != releaseapi.CanaryTrasitionNone {
+		return nil
+	}
+	patch := fmt.Sprintf(`{"spec": {"transition": "%s"}}`, releaseapi.CanaryTrasitionAdopted)
+	_, err := crc.client.ReleaseV1alpha1().CanaryReleases(cr.Namespace).Patch(cr.Name, types.MergePatchType, []byte(patch))
+	return err
+}
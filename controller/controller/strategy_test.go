@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestProgress(t *testing.T) {
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	minutes := func(m int) *metav1.Duration {
+		return &metav1.Duration{Duration: time.Duration(m) * time.Minute}
+	}
+	steps := []releaseapi.CanaryStep{
+		{Weight: 5, Pause: minutes(10)},
+		{Weight: 50},
+		{Weight: 100, Pause: minutes(30)},
+	}
+	status := func(step int32, startMinute int) *releaseapi.CanaryStrategyStatus {
+		return &releaseapi.CanaryStrategyStatus{
+			CurrentStep:   step,
+			StepStartTime: metav1.NewTime(start.Add(time.Duration(startMinute) * time.Minute)),
+		}
+	}
+
+	tests := []struct {
+		name      string
+		autoAdopt bool
+		status    *releaseapi.CanaryStrategyStatus
+		now       int
+		step      int32
+		next      int
+		adopt     bool
+	}{
+		{"start", false, nil, 0, 0, 10, false},
+		{"pausing", false, status(0, 0), 5, 0, 10, false},
+		{"next step", false, status(0, 0), 12, 1, 12, false},
+		{"step without pause", false, status(1, 12), 13, 2, -1, false},
+		{"last step", false, status(2, 13), 100, 2, -1, false},
+		{"last step before adoption", true, status(2, 13), 20, 2, 43, false},
+		{"adopt", true, status(2, 13), 43, 2, 43, true},
+		{"steps changed", false, status(5, 0), 20, 0, 30, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy := &releaseapi.CanaryStrategy{Steps: steps, AutoAdopt: tt.autoAdopt}
+			now := start.Add(time.Duration(tt.now) * time.Minute)
+			got, adopt := progress(strategy, tt.status, now)
+			next := -1
+			if got.NextStepTime != nil {
+				next = int(got.NextStepTime.Sub(start) / time.Minute)
+			}
+			if got.CurrentStep != tt.step || next != tt.next || adopt != tt.adopt {
+				t.Errorf("progress() = step %v, next step at %v, adopt %v, want %v, %v, %v", got.CurrentStep, next, adopt, tt.step, tt.next, tt.adopt)
+			}
+		})
+	}
+}
+
+func TestResumeStrategy(t *testing.T) {
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	next := metav1.NewTime(start.Add(10 * time.Minute))
+	status := &releaseapi.CanaryStrategyStatus{
+		CurrentStep:   1,
+		StepStartTime: metav1.NewTime(start),
+		NextStepTime:  &next,
+	}
+
+	// paused at the 4th minute for an hour, 6 minutes of the step are left after resuming
+	resumeStrategy(status, time.Hour)
+	now := start.Add(64 * time.Minute)
+	if left := status.NextStepTime.Sub(now); left != 6*time.Minute || !status.StepStartTime.Equal(&metav1.Time{Time: start.Add(time.Hour)}) {
+		t.Errorf("resumeStrategy() = %+v, %v left in the step, want 6m", status, left)
+	}
+	resumeStrategy(nil, time.Hour)
+}
+
+func TestPausedSpec(t *testing.T) {
+	cr := &releaseapi.CanaryRelease{}
+	cr.Spec = releaseapi.CanaryReleaseSpec{Release: "web", Version: 2, Paused: true}
+	frozen := pausedSpec(cr)
+	if frozen == nil || frozen.Version != 2 {
+		t.Fatalf("pausedSpec() = %+v, want the spec when paused", frozen)
+	}
+
+	// spec changed while paused is not frozen
+	cr.Status.PausedSpec = frozen
+	cr.Spec.Version = 3
+	if got := pausedSpec(cr); got.Version != 2 {
+		t.Errorf("pausedSpec() = %+v, want the spec frozen before", got)
+	}
+}
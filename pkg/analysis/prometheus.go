@@ -0,0 +1,91 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultQueryTimeout is the timeout of a query
+const defaultQueryTimeout = 10 * time.Second
+
+// Prometheus queries a Prometheus compatible server by its HTTP API
+type Prometheus struct {
+	address string
+	client  *http.Client
+}
+
+// NewPrometheus returns a client of the server, like http://prometheus:9090
+func NewPrometheus(address string) *Prometheus {
+	return &Prometheus{
+		address: strings.TrimSuffix(address, "/"),
+		client:  &http.Client{Timeout: defaultQueryTimeout},
+	}
+}
+
+// queryResponse is the response of an instant query
+type queryResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// sample is a sample of a vector
+type sample struct {
+	Metric map[string]string `json:"metric"`
+	Value  []interface{}     `json:"value"`
+}
+
+// Query runs the instant query, the result must be a scalar or a vector with one sample
+func (p *Prometheus) Query(query string) (float64, error) {
+	resp, err := p.client.Get(p.address + "/api/v1/query?" + url.Values{"query": {query}}.Encode())
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var qr queryResponse
+	if err := json.NewDecoder(resp.Body).Decode(&qr); err != nil {
+		return 0, fmt.Errorf("Error decode query response with status code %v: %v", resp.StatusCode, err)
+	}
+	if qr.Status != "success" {
+		return 0, fmt.Errorf("query failed with %v: %v", qr.ErrorType, qr.Error)
+	}
+
+	var value []interface{}
+	switch qr.Data.ResultType {
+	case "scalar":
+		if err := json.Unmarshal(qr.Data.Result, &value); err != nil {
+			return 0, err
+		}
+	case "vector":
+		var samples []sample
+		if err := json.Unmarshal(qr.Data.Result, &samples); err != nil {
+			return 0, err
+		}
+		if len(samples) != 1 {
+			return 0, fmt.Errorf("query returns %d samples, want 1", len(samples))
+		}
+		value = samples[0].Value
+	default:
+		return 0, fmt.Errorf("unsupported result type %q", qr.Data.ResultType)
+	}
+
+	// value is [<unix time>, "<value>"]
+	if len(value) != 2 {
+		return 0, fmt.Errorf("invalid sample %v", value)
+	}
+	s, ok := value[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("invalid sample value %v", value[1])
+	}
+	return strconv.ParseFloat(s, 64)
+}
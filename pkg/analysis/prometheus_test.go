@@ -0,0 +1,51 @@
+package analysis
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrometheusQuery(t *testing.T) {
+	responses := map[string]string{
+		"scalar(1)":    `{"status":"success","data":{"resultType":"scalar","result":[1546300800,"1"]}}`,
+		"error_rate":   `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"side":"canary"},"value":[1546300800,"0.05"]}]}}`,
+		"no_samples":   `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+		"rate(invalid": `{"status":"error","errorType":"bad_data","error":"parse error"}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		resp, ok := responses[r.URL.Query().Get("query")]
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, resp)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		query   string
+		value   float64
+		wantErr bool
+	}{
+		{"scalar(1)", 1, false},
+		{"error_rate", 0.05, false},
+		{"no_samples", 0, true},
+		{"rate(invalid", 0, true},
+		{"unknown", 0, true},
+	}
+	p := NewPrometheus(server.URL + "/")
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			value, err := p.Query(tt.query)
+			if (err != nil) != tt.wantErr || value != tt.value {
+				t.Errorf("Query() = %v, %v, want %v and error %v", value, err, tt.value, tt.wantErr)
+			}
+		})
+	}
+}
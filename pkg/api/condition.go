@@ -13,6 +13,35 @@ const (
 	ReasonDeprecated = string(releaseapi.CanaryTrasitionDeprecated)
 	ReasonAdopted    = string(releaseapi.CanaryTrasitionAdopted)
 	ReasonError      = "Error"
+	// ReasonCanaryFailover means canary endpoints fail and traffic fails over to origin
+	ReasonCanaryFailover = "CanaryFailover"
+	// ReasonCanaryRecovered means canary endpoints serve traffic again
+	ReasonCanaryRecovered = "CanaryRecovered"
+	// ReasonDraining means new traffic goes to origin and active connections
+	// to canary are finishing before canary is deleted
+	ReasonDraining = "Draining"
+	// ReasonDegraded means fewer proxies are ready than desired
+	ReasonDegraded = "Degraded"
+	// ReasonStepped means the strategy moved to a new step
+	ReasonStepped = "Stepped"
+	// ReasonAnalysisFailed means a metric of analysis is out of range
+	ReasonAnalysisFailed = "AnalysisFailed"
+	// ReasonAnalysisError means a metric of analysis can't be evaluated,
+	// steps wait until it can
+	ReasonAnalysisError = "AnalysisError"
+	// ReasonComparisonFailed means canary serves worse than origin
+	ReasonComparisonFailed = "ComparisonFailed"
+	// ReasonGateRejected means a gate rejected a step or the adoption
+	ReasonGateRejected = "GateRejected"
+	// ReasonPaused means the canary release is frozen by spec
+	ReasonPaused = "Paused"
+	// ReasonResumed means the paused canary release goes on
+	ReasonResumed = "Resumed"
+	// ReasonDeadlineExceeded means the canary release is not adopted by the deadline
+	ReasonDeadlineExceeded = "DeadlineExceeded"
+	// ReasonProgressDeadlineExceeded means the canary release doesn't become
+	// available by the progress deadline
+	ReasonProgressDeadlineExceeded = "ProgressDeadlineExceeded"
 )
 
 // NewConditionFrom creates a new condition from error
@@ -24,13 +53,14 @@ func NewConditionFrom(err error) releaseapi.CanaryReleaseCondition {
 func NewCondition(reason, message string) releaseapi.CanaryReleaseCondition {
 	var typ releaseapi.CanaryReleaseConditionType
 	switch reason {
-	case ReasonAvailable:
+	case ReasonAvailable, ReasonCanaryRecovered:
 		typ = releaseapi.CanaryReleaseAvailable
 	case ReasonDeprecated, ReasonAdopted:
 		typ = releaseapi.CanaryReleaseArchived
-	case ReasonCreating, ReasonUpdating:
+	case ReasonCreating, ReasonUpdating, ReasonDraining, ReasonStepped, ReasonPaused, ReasonResumed:
 		typ = releaseapi.CanaryReleaseProgressing
-	case ReasonError:
+	case ReasonError, ReasonCanaryFailover, ReasonDegraded, ReasonAnalysisFailed, ReasonAnalysisError,
+		ReasonComparisonFailed, ReasonGateRejected, ReasonDeadlineExceeded, ReasonProgressDeadlineExceeded:
 		typ = releaseapi.CanaryReleaseFailure
 	}
 
@@ -43,3 +73,9 @@ func NewCondition(reason, message string) releaseapi.CanaryReleaseCondition {
 	}
 	return condition
 }
+
+// IsLastCondition returns true if the last condition has the reason and message
+func IsLastCondition(cr *releaseapi.CanaryRelease, reason, message string) bool {
+	n := len(cr.Status.Conditions)
+	return n > 0 && cr.Status.Conditions[n-1].Reason == reason && cr.Status.Conditions[n-1].Message == message
+}
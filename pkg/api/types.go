@@ -1,9 +1,56 @@
 package api
 
 import (
+	"fmt"
+	"strings"
+
 	core "k8s.io/api/core/v1"
 )
 
+// HTTPService describes a L7 http service.
+// Traffic is distributed per request instead of per connection.
+type HTTPService struct {
+	// Port extenrnal port to expose
+	Port int32 `json:"port"`
+	// Backend of the service
+	Backend L4Backend `json:"backend"`
+	// Endpoints active endpoints of the service
+	Endpoints []Endpoint `json:"endpoints"`
+	// Rules route matched requests to canary endpoints regardless of the weight
+	Rules []HTTPRule `json:"rules,omitempty"`
+	// Stickiness keeps clients on one side by cookie if it is not nil
+	Stickiness *HTTPStickiness `json:"stickiness,omitempty"`
+	// MirrorPercent is the percentage of requests copied to canary endpoints.
+	// If it is greater than zero, all requests are served by origin endpoints
+	MirrorPercent int32 `json:"mirrorPercent,omitempty"`
+	// GRPC indicates the service speaks gRPC over cleartext HTTP/2,
+	// the weight is applied to each RPC
+	GRPC bool `json:"grpc,omitempty"`
+	// Drained sends requests routed to canary by rules and cookies to origin
+	// endpoints, and drops mirrored requests. It's applied without reloading
+	Drained bool `json:"drained,omitempty"`
+}
+
+// HTTPRule describes a request header match rule
+type HTTPRule struct {
+	// Header name of the request header
+	Header string `json:"header"`
+	// Value matches the header value exactly
+	Value string `json:"value,omitempty"`
+	// Regex matches the header value by regular expression
+	Regex string `json:"regex,omitempty"`
+}
+
+// HTTPStickiness describes the cookie based session affinity
+type HTTPStickiness struct {
+	// Cookie name of the cookie
+	Cookie string `json:"cookie"`
+	// MaxAge lifetime of the cookie in seconds, zero means session cookie
+	MaxAge int32 `json:"maxAge"`
+	// CanaryWeight percentage of new clients assigned to canary
+	CanaryWeight int32 `json:"canaryWeight"`
+}
+
 // L4Service describes a L4 service.
 type L4Service struct {
 	// Port extenrnal port to expose
@@ -12,6 +59,15 @@ type L4Service struct {
 	Backend L4Backend `json:"backend"`
 	// Endpoints active endpoints of the service
 	Endpoints []Endpoint `json:"endpoints"`
+	// HashKey is the key of consistent hashing, e.g. $remote_addr.
+	// Empty means weighted round-robin
+	HashKey string `json:"hashKey,omitempty"`
+	// ServerNames are TLS server names routed to canary endpoints
+	// regardless of the weight
+	ServerNames []string `json:"serverNames,omitempty"`
+	// Drained sends connections routed to canary by server names to origin
+	// endpoints. It's applied without reloading
+	Drained bool `json:"drained,omitempty"`
 }
 
 // L4Backend describes the kubernetes service behind L4 Ingress service
@@ -31,4 +87,39 @@ type Endpoint struct {
 	Port int32 `json:"port"`
 	// Weight of the endpoint
 	Weight int32 `json:"weight"`
+	// Canary indicates whether the endpoint belongs to the canary side
+	Canary bool `json:"canary"`
+}
+
+// Backend describes an upstream whose endpoints are configured
+// in the running proxy without reloading
+type Backend struct {
+	// Name of the upstream
+	Name string `json:"name"`
+	// HashKey is the key of consistent hashing, empty means weighted round-robin
+	HashKey string `json:"hashKey,omitempty"`
+	// CanaryWeight percentage of new sticky clients assigned to canary
+	CanaryWeight int32 `json:"canaryWeight,omitempty"`
+	// MaxFails is the number of failed tries to an endpoint in FailTimeout
+	// seconds before the endpoint is considered down for FailTimeout seconds,
+	// zero disables it
+	MaxFails int `json:"maxFails,omitempty"`
+	// FailTimeout in seconds
+	FailTimeout int `json:"failTimeout,omitempty"`
+	// Endpoints of the upstream
+	Endpoints []Endpoint `json:"endpoints"`
+}
+
+// UpstreamName returns the name of the upstream
+func (s HTTPService) UpstreamName() string {
+	return upstreamName("http", s.Port, s.Backend)
+}
+
+// UpstreamName returns the name of the upstream
+func (s L4Service) UpstreamName() string {
+	return upstreamName(strings.ToLower(string(s.Backend.Protocol)), s.Port, s.Backend)
+}
+
+func upstreamName(prefix string, port int32, backend L4Backend) string {
+	return fmt.Sprintf("%s-%d-%s-%s-%d", prefix, port, backend.Namespace, backend.Name, backend.Port)
 }
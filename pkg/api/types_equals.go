@@ -4,14 +4,37 @@ import (
 	"reflect"
 )
 
+// Equal tests for equality between two HTTPService types
+func (s HTTPService) Equal(s2 HTTPService) bool {
+	if !endpointsEqual(s.Endpoints, s2.Endpoints) {
+		return false
+	}
+	s.Endpoints = nil
+	s2.Endpoints = nil
+
+	return reflect.DeepEqual(s, s2)
+}
+
 // Equal tests for equality between two L4Service types
 func (s L4Service) Equal(s2 L4Service) bool {
-	if len(s.Endpoints) != len(s2.Endpoints) {
+	if !endpointsEqual(s.Endpoints, s2.Endpoints) {
+		return false
+	}
+	s.Endpoints = nil
+	s2.Endpoints = nil
+
+	return reflect.DeepEqual(s, s2)
+}
+
+// endpointsEqual tests for equality between two Endpoint slices
+// regardless of their order
+func endpointsEqual(e1, e2 []Endpoint) bool {
+	if len(e1) != len(e2) {
 		return false
 	}
-	for _, s1e := range s.Endpoints {
+	for _, s1e := range e1 {
 		found := false
-		for _, s2e := range s2.Endpoints {
+		for _, s2e := range e2 {
 			if reflect.DeepEqual(s1e, s2e) {
 				found = true
 				break
@@ -21,8 +44,5 @@ func (s L4Service) Equal(s2 L4Service) bool {
 			return false
 		}
 	}
-	s.Endpoints = nil
-	s2.Endpoints = nil
-
-	return reflect.DeepEqual(s, s2)
+	return true
 }
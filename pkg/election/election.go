@@ -0,0 +1,220 @@
+package election
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	log "github.com/zoumo/logdog"
+	coordination "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	coordinationv1 "k8s.io/client-go/kubernetes/typed/coordination/v1"
+)
+
+const (
+	// DefaultLeaseDuration is the default duration that non-leader candidates
+	// wait before taking over a lease which is not renewed
+	DefaultLeaseDuration = 15 * time.Second
+	// DefaultRenewDeadline is the default duration that the leader keeps
+	// retrying to renew the lease before giving up leadership
+	DefaultRenewDeadline = 10 * time.Second
+	// DefaultRetryPeriod is the default interval between tries to acquire or renew
+	DefaultRetryPeriod = 2 * time.Second
+)
+
+// Config is the configuration of an elector
+type Config struct {
+	// Client is the client of leases
+	Client coordinationv1.LeasesGetter
+	// Namespace and Name are the lease used as the lock
+	Namespace string
+	Name      string
+	// Identity is the unique name of this candidate, such as the pod name
+	Identity string
+	// LeaseDuration, RenewDeadline and RetryPeriod use the defaults if they are zero
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+	// OnChange is called when this candidate becomes or stops being the leader
+	OnChange func(leader bool)
+}
+
+// Elector elects a leader among candidates by a Lease. The leader renews
+// the lease periodically, others take it over once it's not renewed in the
+// lease duration.
+type Elector struct {
+	cfg Config
+
+	mu        sync.RWMutex
+	leader    bool
+	lastRenew time.Time
+
+	// the last observed lease spec and when it's observed in local clock,
+	// the renew time in the lease is not trusted because of clock skew
+	observedSpec coordination.LeaseSpec
+	observedTime time.Time
+}
+
+// NewElector returns an elector, it's not the leader until Run acquires the lease
+func NewElector(cfg Config) *Elector {
+	if cfg.LeaseDuration <= 0 {
+		cfg.LeaseDuration = DefaultLeaseDuration
+	}
+	if cfg.RenewDeadline <= 0 {
+		cfg.RenewDeadline = DefaultRenewDeadline
+	}
+	if cfg.RenewDeadline >= cfg.LeaseDuration {
+		// the leader must give up before others take over
+		cfg.RenewDeadline = cfg.LeaseDuration * 2 / 3
+	}
+	if cfg.RetryPeriod <= 0 {
+		cfg.RetryPeriod = DefaultRetryPeriod
+	}
+	return &Elector{cfg: cfg}
+}
+
+// IsLeader returns true if this candidate holds the lease
+func (e *Elector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.leader
+}
+
+// Run tries to acquire or renew the lease until stopCh is closed,
+// then releases the lease if this candidate is the leader
+func (e *Elector) Run(stopCh <-chan struct{}) {
+	log.Info("Start leader election", log.Fields{"lease": e.cfg.Namespace + "/" + e.cfg.Name, "identity": e.cfg.Identity})
+	wait.Until(func() {
+		e.try(time.Now())
+	}, e.cfg.RetryPeriod, stopCh)
+	e.release()
+}
+
+// try acquires or renews the lease, and updates the leadership.
+// The leader keeps leadership until it fails to renew in the renew deadline.
+func (e *Elector) try(now time.Time) {
+	acquired, err := e.tryAcquireOrRenew(now)
+	if err != nil {
+		log.Warn("Error acquire or renew lease", log.Fields{"lease": e.cfg.Name, "err": err})
+	}
+
+	e.mu.Lock()
+	if acquired {
+		e.lastRenew = now
+	}
+	leader := acquired || (e.leader && err != nil && now.Sub(e.lastRenew) < e.cfg.RenewDeadline)
+	changed := leader != e.leader
+	e.leader = leader
+	e.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	log.Info("Leadership changed", log.Fields{"lease": e.cfg.Name, "identity": e.cfg.Identity, "leader": leader})
+	if e.cfg.OnChange != nil {
+		e.cfg.OnChange(leader)
+	}
+}
+
+// tryAcquireOrRenew returns true if this candidate holds the lease now
+func (e *Elector) tryAcquireOrRenew(now time.Time) (bool, error) {
+	leases := e.cfg.Client.Leases(e.cfg.Namespace)
+	lease, err := leases.Get(e.cfg.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		lease = &coordination.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      e.cfg.Name,
+				Namespace: e.cfg.Namespace,
+			},
+			Spec: e.holdSpec(coordination.LeaseSpec{}, now),
+		}
+		created, err := leases.Create(lease)
+		if err != nil {
+			return false, err
+		}
+		e.observe(created.Spec, now)
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if !reflect.DeepEqual(e.observedSpec, lease.Spec) {
+		e.observe(lease.Spec, now)
+	}
+	holder := ""
+	if lease.Spec.HolderIdentity != nil {
+		holder = *lease.Spec.HolderIdentity
+	}
+	duration := e.cfg.LeaseDuration
+	if lease.Spec.LeaseDurationSeconds != nil {
+		duration = time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	}
+	if holder != "" && holder != e.cfg.Identity && now.Before(e.observedTime.Add(duration)) {
+		// held by another candidate
+		return false, nil
+	}
+
+	lease = lease.DeepCopy()
+	lease.Spec = e.holdSpec(lease.Spec, now)
+	updated, err := leases.Update(lease)
+	if err != nil {
+		return false, err
+	}
+	e.observe(updated.Spec, now)
+	return true, nil
+}
+
+// holdSpec returns the lease spec held by this candidate
+func (e *Elector) holdSpec(spec coordination.LeaseSpec, now time.Time) coordination.LeaseSpec {
+	renewTime := metav1.NewMicroTime(now)
+	if spec.HolderIdentity == nil || *spec.HolderIdentity != e.cfg.Identity {
+		transitions := int32(0)
+		if spec.LeaseTransitions != nil {
+			transitions = *spec.LeaseTransitions
+		}
+		if spec.HolderIdentity != nil && *spec.HolderIdentity != "" {
+			transitions++
+		}
+		identity := e.cfg.Identity
+		spec.HolderIdentity = &identity
+		spec.AcquireTime = &renewTime
+		spec.LeaseTransitions = &transitions
+	}
+	seconds := int32(e.cfg.LeaseDuration / time.Second)
+	spec.LeaseDurationSeconds = &seconds
+	spec.RenewTime = &renewTime
+	return spec
+}
+
+func (e *Elector) observe(spec coordination.LeaseSpec, now time.Time) {
+	e.observedSpec = spec
+	e.observedTime = now
+}
+
+// release clears the holder of the lease, other candidates can take it over
+// without waiting for the lease duration
+func (e *Elector) release() {
+	if !e.IsLeader() {
+		return
+	}
+	leases := e.cfg.Client.Leases(e.cfg.Namespace)
+	lease, err := leases.Get(e.cfg.Name, metav1.GetOptions{})
+	if err == nil && lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity == e.cfg.Identity {
+		lease = lease.DeepCopy()
+		lease.Spec.HolderIdentity = nil
+		_, err = leases.Update(lease)
+	}
+	if err != nil {
+		log.Warn("Error release lease", log.Fields{"lease": e.cfg.Name, "err": err})
+	}
+
+	e.mu.Lock()
+	e.leader = false
+	e.mu.Unlock()
+	if e.cfg.OnChange != nil {
+		e.cfg.OnChange(false)
+	}
+}
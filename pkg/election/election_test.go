@@ -0,0 +1,47 @@
+package election
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestElector(t *testing.T) {
+	client := fake.NewSimpleClientset().CoordinationV1()
+	newElector := func(identity string) *Elector {
+		return NewElector(Config{Client: client, Namespace: "test", Name: "lock", Identity: identity})
+	}
+	a, b := newElector("a"), newElector("b")
+	now := time.Now()
+
+	for i, step := range []struct {
+		elector *Elector
+		at      time.Duration
+		release bool
+		leaderA bool
+		leaderB bool
+	}{
+		{a, 0, false, true, false},
+		{b, 0, false, true, false},
+		// a renews the lease
+		{a, 10 * time.Second, false, true, false},
+		{b, 11 * time.Second, false, true, false},
+		// the lease is not renewed in the lease duration since b observed it
+		{b, 25 * time.Second, false, true, false},
+		{b, 27 * time.Second, false, true, true},
+		{a, 28 * time.Second, false, false, true},
+		// b releases the lease, a takes it over at once
+		{b, 29 * time.Second, true, false, false},
+		{a, 29 * time.Second, false, true, false},
+	} {
+		if step.release {
+			step.elector.release()
+		} else {
+			step.elector.try(now.Add(step.at))
+		}
+		if a.IsLeader() != step.leaderA || b.IsLeader() != step.leaderB {
+			t.Errorf("step %v: leaders are a %v and b %v, want %v and %v", i, a.IsLeader(), b.IsLeader(), step.leaderA, step.leaderB)
+		}
+	}
+}
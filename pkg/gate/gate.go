@@ -0,0 +1,97 @@
+package gate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+)
+
+const (
+	// defaultTimeout is the timeout of a gate if it's not set
+	defaultTimeout = 10 * time.Second
+	// maxMessageSize is the most bytes of a response kept in the error
+	maxMessageSize = 256
+)
+
+// Payload is the JSON body posted to gates
+type Payload struct {
+	// Type is when the gate is called
+	Type releaseapi.CanaryGateType `json:"type"`
+	// Namespace of the canary release
+	Namespace string `json:"namespace"`
+	// Name of the canary release
+	Name string `json:"name"`
+	// Release is the name of the release
+	Release string `json:"release"`
+	// Version is the version of the release
+	Version int32 `json:"version"`
+	// Step is the index of the step to start, only for Step gates
+	Step *int32 `json:"step,omitempty"`
+	// Weight is the weight of canary in the step, only for Step gates
+	Weight *int32 `json:"weight,omitempty"`
+}
+
+// NewPayload returns the payload of gates of the type
+func NewPayload(cr *releaseapi.CanaryRelease, typ releaseapi.CanaryGateType) Payload {
+	return Payload{
+		Type:      typ,
+		Namespace: cr.Namespace,
+		Name:      cr.Name,
+		Release:   cr.Spec.Release,
+		Version:   cr.Spec.Version,
+	}
+}
+
+// Check calls the gates of the payload type in order, it returns the error of
+// the first gate that rejects
+func Check(gates []releaseapi.CanaryGate, payload Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	for _, gate := range gates {
+		if gate.Type != payload.Type {
+			continue
+		}
+		if err := call(gate, body); err != nil {
+			return fmt.Errorf("gate %v rejected: %v", gate.Name, err)
+		}
+	}
+	return nil
+}
+
+// Has returns true if any gate is of the type
+func Has(gates []releaseapi.CanaryGate, typ releaseapi.CanaryGateType) bool {
+	for _, gate := range gates {
+		if gate.Type == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// call posts the body to the gate, any status other than 2xx is a rejection
+func call(gate releaseapi.CanaryGate, body []byte) error {
+	timeout := defaultTimeout
+	if gate.TimeoutSeconds > 0 {
+		timeout = time.Duration(gate.TimeoutSeconds) * time.Second
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post(gate.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxMessageSize))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%v %s", resp.Status, bytes.TrimSpace(message))
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package gate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheck(t *testing.T) {
+	var received []Payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload Payload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received = append(received, payload)
+		if r.URL.Path == "/reject" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "tests failed")
+		}
+	}))
+	defer server.Close()
+
+	cr := &releaseapi.CanaryRelease{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-canary"},
+		Spec:       releaseapi.CanaryReleaseSpec{Release: "web", Version: 3},
+	}
+	tests := []struct {
+		name     string
+		gates    []releaseapi.CanaryGate
+		calls    int
+		rejected bool
+	}{
+		{"no gates", nil, 0, false},
+		{"accepted", []releaseapi.CanaryGate{
+			{Name: "tests", Type: releaseapi.CanaryGateStep, URL: server.URL + "/accept"},
+		}, 1, false},
+		{"rejected", []releaseapi.CanaryGate{
+			{Name: "tests", Type: releaseapi.CanaryGateStep, URL: server.URL + "/reject"},
+			{Name: "change", Type: releaseapi.CanaryGateStep, URL: server.URL + "/accept"},
+		}, 1, true},
+		{"other types skipped", []releaseapi.CanaryGate{
+			{Name: "change", Type: releaseapi.CanaryGateAdopt, URL: server.URL + "/reject"},
+		}, 0, false},
+		{"unreachable", []releaseapi.CanaryGate{
+			{Name: "tests", Type: releaseapi.CanaryGateStep, URL: "http://127.0.0.1:0"},
+		}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received = nil
+			weight := int32(20)
+			payload := NewPayload(cr, releaseapi.CanaryGateStep)
+			payload.Weight = &weight
+			err := Check(tt.gates, payload)
+			if (err != nil) != tt.rejected || len(received) != tt.calls {
+				t.Fatalf("Check() = %v with %d calls, want rejected %v with %d calls", err, len(received), tt.rejected, tt.calls)
+			}
+			for _, p := range received {
+				if p.Name != cr.Name || p.Release != "web" || p.Version != 3 || p.Weight == nil || *p.Weight != weight {
+					t.Errorf("gate received %+v", p)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package health
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+	log "github.com/zoumo/logdog"
+)
+
+const (
+	defaultPath             = "/"
+	defaultIntervalSeconds  = 10
+	defaultTimeoutSeconds   = 2
+	defaultFailureThreshold = 3
+	defaultSuccessThreshold = 1
+	// maxReplySize is the max size of a UDP reply
+	maxReplySize = 64 * 1024
+)
+
+// Target is a side of a service port to probe
+type Target struct {
+	// Service is the name of the service
+	Service string
+	// Port is the port of the service
+	Port int32
+	// Canary indicates whether it's the canary side
+	Canary bool
+	// Address is the host:port to probe
+	Address string
+	// Check is the probe settings, zero fields use the defaults
+	Check releaseapi.CanaryHealthCheck
+}
+
+func (t Target) key() string {
+	return fmt.Sprintf("%s/%d/%v", t.Service, t.Port, t.Canary)
+}
+
+// Result is the health of a target
+type Result struct {
+	Target
+	// Healthy is true until the target fails FailureThreshold probes in a row
+	Healthy bool
+	// LastTransitionTime is the last time Healthy changed
+	LastTransitionTime time.Time
+	// Message is the error of the last failed probe
+	Message string
+}
+
+// Prober probes targets periodically and calls onChange
+// when any target becomes healthy or unhealthy
+type Prober struct {
+	mu       sync.RWMutex
+	probes   map[string]*probe
+	onChange func()
+}
+
+type probe struct {
+	stopCh    chan struct{}
+	result    Result
+	successes int32
+	failures  int32
+}
+
+// NewProber returns a prober without targets
+func NewProber(onChange func()) *Prober {
+	return &Prober{
+		probes:   make(map[string]*probe),
+		onChange: onChange,
+	}
+}
+
+// Update starts probing new targets and stops probing removed ones,
+// results of unchanged targets are kept
+func (p *Prober) Update(targets []Target) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	keep := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		key := t.key()
+		keep[key] = true
+		if old, ok := p.probes[key]; ok {
+			if reflect.DeepEqual(old.result.Target, t) {
+				continue
+			}
+			close(old.stopCh)
+		}
+		pb := &probe{
+			stopCh: make(chan struct{}),
+			result: Result{Target: t, Healthy: true, LastTransitionTime: time.Now()},
+		}
+		p.probes[key] = pb
+		go p.run(pb, t.Address, withDefaults(t.Check))
+	}
+
+	for key, pb := range p.probes {
+		if !keep[key] {
+			close(pb.stopCh)
+			delete(p.probes, key)
+		}
+	}
+}
+
+// Stop stops probing all targets
+func (p *Prober) Stop() {
+	p.Update(nil)
+}
+
+// Healthy returns false if the side of the service port is unhealthy,
+// sides without health checks are healthy
+func (p *Prober) Healthy(service string, port int32, canary bool) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	pb, ok := p.probes[Target{Service: service, Port: port, Canary: canary}.key()]
+	return !ok || pb.result.Healthy
+}
+
+// Results returns the health of all targets sorted by service, port and side
+func (p *Prober) Results() []Result {
+	p.mu.RLock()
+	results := make([]Result, 0, len(p.probes))
+	for _, pb := range p.probes {
+		results = append(results, pb.result)
+	}
+	p.mu.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return !a.Canary && b.Canary
+	})
+	return results
+}
+
+func (p *Prober) run(pb *probe, address string, check releaseapi.CanaryHealthCheck) {
+	ticker := time.NewTicker(time.Duration(check.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		err := Probe(address, check)
+		if p.record(pb, check, err) {
+			p.onChange()
+		}
+
+		select {
+		case <-pb.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// record updates the result by the probe error,
+// it returns true if the target becomes healthy or unhealthy
+func (p *Prober) record(pb *probe, check releaseapi.CanaryHealthCheck, err error) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	select {
+	case <-pb.stopCh:
+		// the target has been removed or changed
+		return false
+	default:
+	}
+
+	result := &pb.result
+	if err == nil {
+		pb.failures = 0
+		pb.successes++
+		if result.Healthy || pb.successes < check.SuccessThreshold {
+			return false
+		}
+		result.Healthy = true
+		result.Message = ""
+	} else {
+		pb.successes = 0
+		pb.failures++
+		result.Message = err.Error()
+		if !result.Healthy || pb.failures < check.FailureThreshold {
+			return false
+		}
+		result.Healthy = false
+	}
+
+	result.LastTransitionTime = time.Now()
+	log.Info("Health of canary side changed", log.Fields{
+		"service": result.Service,
+		"port":    result.Port,
+		"canary":  result.Canary,
+		"healthy": result.Healthy,
+		"message": result.Message,
+	})
+	return true
+}
+
+// withDefaults fills the zero fields of the check
+func withDefaults(check releaseapi.CanaryHealthCheck) releaseapi.CanaryHealthCheck {
+	if check.Type == "" {
+		check.Type = releaseapi.CanaryHealthCheckTCP
+	}
+	if check.Path == "" {
+		check.Path = defaultPath
+	}
+	if check.IntervalSeconds <= 0 {
+		check.IntervalSeconds = defaultIntervalSeconds
+	}
+	if check.TimeoutSeconds <= 0 {
+		check.TimeoutSeconds = defaultTimeoutSeconds
+	}
+	if check.FailureThreshold <= 0 {
+		check.FailureThreshold = defaultFailureThreshold
+	}
+	if check.SuccessThreshold <= 0 {
+		check.SuccessThreshold = defaultSuccessThreshold
+	}
+	return check
+}
+
+// Probe checks the address once, zero fields of the check use the defaults
+func Probe(address string, check releaseapi.CanaryHealthCheck) error {
+	check = withDefaults(check)
+	timeout := time.Duration(check.TimeoutSeconds) * time.Second
+	switch check.Type {
+	case releaseapi.CanaryHealthCheckHTTP:
+		return probeHTTP(address, check.Path, timeout)
+	case releaseapi.CanaryHealthCheckUDP:
+		return probeUDP(address, check.Send, timeout)
+	case releaseapi.CanaryHealthCheckTCP:
+		return probeTCP(address, timeout)
+	default:
+		return fmt.Errorf("unknown health check type %v", check.Type)
+	}
+}
+
+func probeTCP(address string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func probeHTTP(address, path string, timeout time.Duration) error {
+	client := &http.Client{
+		Timeout: timeout,
+		// a redirect is a successful response
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get("http://" + address + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status code %v", resp.StatusCode)
+	}
+	return nil
+}
+
+func probeUDP(address, send string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("udp", address, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write([]byte(send)); err != nil {
+		return err
+	}
+	buf := make([]byte, maxReplySize)
+	_, err = conn.Read(buf)
+	return err
+}
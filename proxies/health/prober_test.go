@@ -0,0 +1,96 @@
+package health
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+)
+
+func TestProbe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	httpAddress := strings.TrimPrefix(server.URL, "http://")
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddress := closed.Addr().String()
+	closed.Close()
+
+	echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, client, err := echo.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if string(buf[:n]) == "ping" {
+				_, _ = echo.WriteToUDP([]byte("pong"), client)
+			}
+		}
+	}()
+
+	tests := []struct {
+		name    string
+		address string
+		check   releaseapi.CanaryHealthCheck
+		healthy bool
+	}{
+		{"tcp", httpAddress, releaseapi.CanaryHealthCheck{}, true},
+		{"tcp refused", closedAddress, releaseapi.CanaryHealthCheck{}, false},
+		{"http", httpAddress, releaseapi.CanaryHealthCheck{Type: releaseapi.CanaryHealthCheckHTTP, Path: "/healthz"}, true},
+		{"http 500", httpAddress, releaseapi.CanaryHealthCheck{Type: releaseapi.CanaryHealthCheckHTTP}, false},
+		{"udp", echo.LocalAddr().String(), releaseapi.CanaryHealthCheck{Type: releaseapi.CanaryHealthCheckUDP, Send: "ping", TimeoutSeconds: 1}, true},
+		{"udp no reply", echo.LocalAddr().String(), releaseapi.CanaryHealthCheck{Type: releaseapi.CanaryHealthCheckUDP, Send: "hello", TimeoutSeconds: 1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Probe(tt.address, tt.check)
+			if (err == nil) != tt.healthy {
+				t.Errorf("Probe() = %v, want healthy %v", err, tt.healthy)
+			}
+		})
+	}
+}
+
+func TestProberRecord(t *testing.T) {
+	p := NewProber(func() {})
+	pb := &probe{stopCh: make(chan struct{}), result: Result{Healthy: true}}
+	check := withDefaults(releaseapi.CanaryHealthCheck{SuccessThreshold: 2})
+	failed := errors.New("connection refused")
+
+	for i, step := range []struct {
+		err     error
+		changed bool
+		healthy bool
+	}{
+		{failed, false, true},
+		{nil, false, true},
+		{failed, false, true},
+		{failed, false, true},
+		{failed, true, false},
+		{failed, false, false},
+		{nil, false, false},
+		{nil, true, true},
+	} {
+		changed := p.record(pb, check, step.err)
+		if changed != step.changed || pb.result.Healthy != step.healthy {
+			t.Errorf("step %v: record() = %v and healthy %v, want %v and %v", i, changed, pb.result.Healthy, step.changed, step.healthy)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package l4
+
+import (
+	"hash/fnv"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/caicloud/canary-release/pkg/api"
+)
+
+// balancer picks an endpoint by smooth weighted round-robin, the same as
+// nginx does. If hash is enabled, the endpoint is picked by weighted
+// rendezvous hashing on the client IP, so a client sticks to its endpoint
+// as long as the endpoint exists. Endpoints which are down are skipped
+// unless all endpoints are down.
+type balancer struct {
+	mu        sync.Mutex
+	hash      bool
+	endpoints []api.Endpoint
+	current   []int64
+	failures  *endpointFailures
+}
+
+// newBalancer returns a balancer of the endpoints, endpoints without weight are ignored
+func newBalancer(endpoints []api.Endpoint, hash bool, failures *endpointFailures) *balancer {
+	b := &balancer{
+		hash:     hash,
+		failures: failures,
+	}
+	for _, ep := range endpoints {
+		if ep.Weight <= 0 {
+			continue
+		}
+		b.endpoints = append(b.endpoints, ep)
+	}
+	b.current = make([]int64, len(b.endpoints))
+	return b
+}
+
+// newSideBalancer returns a balancer of the canary or origin side,
+// endpoints in the side share the traffic equally
+func newSideBalancer(endpoints []api.Endpoint, canary bool, failures *endpointFailures) *balancer {
+	var side []api.Endpoint
+	for _, ep := range endpoints {
+		if ep.Canary != canary {
+			continue
+		}
+		ep.Weight = 1
+		side = append(side, ep)
+	}
+	return newBalancer(side, false, failures)
+}
+
+// newBalancers returns the weighted balancer and the balancers of each side
+// of the service. Canary of a drained service gets no new connections, the
+// connections routed to canary by server names go to origin instead.
+func newBalancers(svc api.L4Service, failures *endpointFailures) (weighted, origin, canary *balancer) {
+	origin = newSideBalancer(svc.Endpoints, false, failures)
+	if svc.Drained {
+		return newBalancer(origin.endpoints, svc.HashKey != "", failures), origin, origin
+	}
+	weighted = newBalancer(svc.Endpoints, svc.HashKey != "", failures)
+	canary = newSideBalancer(svc.Endpoints, true, failures)
+	return weighted, origin, canary
+}
+
+// pick returns the endpoint for the client, false if there is no endpoint
+func (b *balancer) pick(client string) (api.Endpoint, bool) {
+	if len(b.endpoints) == 0 {
+		return api.Endpoint{}, false
+	}
+	up := b.available()
+	if b.hash {
+		return b.endpoints[b.hashIndex(client, up)], true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	best := -1
+	var total int64
+	for i, ep := range b.endpoints {
+		if !up[i] {
+			continue
+		}
+		b.current[i] += int64(ep.Weight)
+		total += int64(ep.Weight)
+		if best < 0 || b.current[i] > b.current[best] {
+			best = i
+		}
+	}
+	b.current[best] -= total
+	return b.endpoints[best], true
+}
+
+// available returns whether each endpoint is up,
+// all endpoints are considered up if all of them are down
+func (b *balancer) available() []bool {
+	up := make([]bool, len(b.endpoints))
+	found := false
+	for i, ep := range b.endpoints {
+		up[i] = !b.failures.down(ep)
+		found = found || up[i]
+	}
+	if !found {
+		for i := range up {
+			up[i] = true
+		}
+	}
+	return up
+}
+
+// hashIndex returns the index of the endpoint up with the highest score,
+// the score of an endpoint is proportional to its weight
+func (b *balancer) hashIndex(client string, up []bool) int {
+	best := 0
+	bestScore := -1.0
+	for i, ep := range b.endpoints {
+		if !up[i] {
+			continue
+		}
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(client))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(endpointAddress(ep)))
+		// map the hash to (0, 1)
+		u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+		score := float64(ep.Weight) / -math.Log(u)
+		if score > bestScore {
+			best = i
+			bestScore = score
+		}
+	}
+	return best
+}
+
+// endpointAddress returns the dial address of the endpoint
+func endpointAddress(ep api.Endpoint) string {
+	return net.JoinHostPort(ep.Address, strconv.Itoa(int(ep.Port)))
+}
+
+// matchServerName checks whether the TLS server name matches any of the names.
+// Names follow nginx hostnames: *.example.com matches all subdomains and
+// .example.com matches example.com and all its subdomains.
+func matchServerName(serverName string, names []string) bool {
+	serverName = strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if serverName == "" {
+		return false
+	}
+	for _, name := range names {
+		switch {
+		case strings.HasPrefix(name, "*."):
+			if strings.HasSuffix(serverName, name[1:]) {
+				return true
+			}
+		case strings.HasPrefix(name, "."):
+			if serverName == name[1:] || strings.HasSuffix(serverName, name) {
+				return true
+			}
+		default:
+			if serverName == name {
+				return true
+			}
+		}
+	}
+	return false
+}
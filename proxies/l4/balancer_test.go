@@ -0,0 +1,130 @@
+package l4
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/caicloud/canary-release/pkg/api"
+)
+
+func TestBalancerPick(t *testing.T) {
+	failures := newEndpointFailures()
+	b := newBalancer([]api.Endpoint{
+		{Address: "10.0.0.1", Port: 80, Weight: 2},
+		{Address: "10.0.0.2", Port: 80, Weight: 0},
+		{Address: "10.0.1.1", Port: 80, Weight: 1, Canary: true},
+	}, false, failures)
+
+	var got []string
+	for i := 0; i < 6; i++ {
+		ep, ok := b.pick("")
+		if !ok {
+			t.Fatalf("pick() found no endpoints")
+		}
+		got = append(got, ep.Address)
+	}
+	want := []string{"10.0.0.1", "10.0.1.1", "10.0.0.1", "10.0.0.1", "10.0.1.1", "10.0.0.1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pick() = %v, want %v", got, want)
+	}
+
+	// the failed canary is skipped
+	failures.fail(api.Endpoint{Address: "10.0.1.1", Port: 80})
+	for i := 0; i < 3; i++ {
+		if ep, _ := b.pick(""); ep.Address != "10.0.0.1" {
+			t.Errorf("pick() = %v after canary failed, want 10.0.0.1", ep.Address)
+		}
+	}
+
+	if _, ok := newBalancer(nil, false, nil).pick(""); ok {
+		t.Errorf("pick() of empty balancer found an endpoint")
+	}
+}
+
+func TestBalancerHash(t *testing.T) {
+	endpoints := []api.Endpoint{
+		{Address: "10.0.0.1", Port: 80, Weight: 3},
+		{Address: "10.0.0.2", Port: 80, Weight: 3},
+		{Address: "10.0.1.1", Port: 80, Weight: 1, Canary: true},
+	}
+	b := newBalancer(endpoints, true, nil)
+
+	canary := 0
+	for i := 0; i < 700; i++ {
+		client := fmt.Sprintf("192.168.%d.%d", i/256, i%256)
+		first, _ := b.pick(client)
+		second, _ := b.pick(client)
+		if first != second {
+			t.Fatalf("pick(%v) = %v and %v, want the same endpoint", client, first, second)
+		}
+		if first.Canary {
+			canary++
+		}
+	}
+	// 1/7 of clients go to canary
+	if canary < 50 || canary > 150 {
+		t.Errorf("%v of 700 clients go to canary, want about 100", canary)
+	}
+
+	// clients of remaining endpoints are not moved when canary is removed
+	removed := newBalancer(endpoints[:2], true, nil)
+	for i := 0; i < 100; i++ {
+		client := fmt.Sprintf("192.168.0.%d", i)
+		before, _ := b.pick(client)
+		after, _ := removed.pick(client)
+		if !before.Canary && before != after {
+			t.Errorf("pick(%v) moved from %v to %v", client, before, after)
+		}
+	}
+}
+
+func TestMatchServerName(t *testing.T) {
+	tests := []struct {
+		serverName string
+		names      []string
+		want       bool
+	}{
+		{"canary.example.com", []string{"canary.example.com"}, true},
+		{"Canary.Example.com.", []string{"canary.example.com"}, true},
+		{"www.example.com", []string{"canary.example.com"}, false},
+		{"a.b.example.com", []string{"*.example.com"}, true},
+		{"example.com", []string{"*.example.com"}, false},
+		{"example.com", []string{".example.com"}, true},
+		{"a.example.com", []string{".example.com"}, true},
+		{"badexample.com", []string{".example.com"}, false},
+		{"", []string{"*.example.com"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			if got := matchServerName(tt.serverName, tt.names); got != tt.want {
+				t.Errorf("matchServerName(%v, %v) = %v, want %v", tt.serverName, tt.names, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewBalancersDrained(t *testing.T) {
+	svc := api.L4Service{
+		Endpoints: []api.Endpoint{
+			{Address: "10.0.0.1", Port: 80, Weight: 1},
+			{Address: "10.0.0.2", Port: 80, Weight: 1},
+			{Address: "10.0.1.1", Port: 80, Weight: 8, Canary: true},
+		},
+		ServerNames: []string{"canary.example.com"},
+		Drained:     true,
+	}
+	for _, hash := range []string{"", "$remote_addr"} {
+		svc.HashKey = hash
+		weighted, _, canary := newBalancers(svc, nil)
+		for i := 0; i < 100; i++ {
+			client := fmt.Sprintf("192.168.0.%d", i)
+			if ep, _ := weighted.pick(client); ep.Canary {
+				t.Errorf("pick(%v) of drained service with hash key %q = %v, want origin", client, hash, ep)
+			}
+			if ep, _ := canary.pick(client); ep.Canary {
+				t.Errorf("pick(%v) by server names of drained service = %v, want origin", client, ep)
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package l4
+
+import (
+	"sync"
+	"time"
+
+	"github.com/caicloud/canary-release/pkg/api"
+	log "github.com/zoumo/logdog"
+)
+
+const (
+	// maxFails is the number of failed connections to an endpoint
+	// in failTimeout before the endpoint is down
+	maxFails = 1
+	// failTimeout is how long a down endpoint is skipped,
+	// the same as the nginx provider
+	failTimeout = 10 * time.Second
+)
+
+// endpointFailures tracks failed connections to endpoints like nginx
+// max_fails and fail_timeout. It's safe for concurrent use, and a nil
+// endpointFailures considers all endpoints up.
+type endpointFailures struct {
+	mu    sync.Mutex
+	fails map[string]*endpointFail
+}
+
+type endpointFail struct {
+	count int
+	// since is the time of the first failure, or the time the endpoint is down
+	since time.Time
+}
+
+func newEndpointFailures() *endpointFailures {
+	return &endpointFailures{
+		fails: make(map[string]*endpointFail),
+	}
+}
+
+// fail records a failed connection to the endpoint
+func (f *endpointFailures) fail(ep api.Endpoint) {
+	if f == nil {
+		return
+	}
+	key := endpointAddress(ep)
+	now := time.Now()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fail, ok := f.fails[key]
+	if !ok || now.Sub(fail.since) > failTimeout {
+		fail = &endpointFail{since: now}
+		f.fails[key] = fail
+	}
+	fail.count++
+	if fail.count == maxFails {
+		// the endpoint is down for a whole failTimeout from now
+		fail.since = now
+		log.Warn("Endpoint is down", log.Fields{"endpoint": key, "timeout": failTimeout})
+	}
+}
+
+// down checks whether the endpoint failed maxFails times recently
+func (f *endpointFailures) down(ep api.Endpoint) bool {
+	if f == nil {
+		return false
+	}
+	key := endpointAddress(ep)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fail, ok := f.fails[key]
+	if !ok {
+		return false
+	}
+	if time.Since(fail.since) > failTimeout {
+		delete(f.fails, key)
+		return false
+	}
+	return fail.count >= maxFails
+}
@@ -0,0 +1,204 @@
+package l4
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/caicloud/canary-release/pkg/api"
+	"github.com/caicloud/canary-release/proxies/provider"
+	log "github.com/zoumo/logdog"
+)
+
+const (
+	// drainTimeout is the max time to wait for established connections when stopping
+	drainTimeout = 30 * time.Second
+)
+
+var (
+	errNoEndpoints  = errors.New("no endpoints")
+	errServerClosed = errors.New("server closed")
+)
+
+var (
+	_ provider.TrafficProvider = &Proxy{}
+	_ provider.StatsCollector  = &Proxy{}
+)
+
+// server serves a port of a canary release
+type server interface {
+	update(svc api.L4Service)
+	close()
+	closeConns()
+	wait()
+	health() error
+	snapshot() []provider.SideStats
+}
+
+// Proxy is a pure-Go weighted TCP and UDP proxy. Weights are applied per
+// connection for TCP and per session for UDP. HTTP ports are proxied as
+// TCP ports, so rules, stickiness and mirroring are not supported.
+// Listeners are kept across changes of weights and endpoints.
+type Proxy struct {
+	mu      sync.Mutex
+	servers map[string]server
+	stopped bool
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewProxy returns a new Proxy
+func NewProxy() *Proxy {
+	return &Proxy{
+		servers: make(map[string]server),
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// Start blocks until the proxy is stopped
+func (p *Proxy) Start() {
+	log.Info("Starting l4 proxy...")
+	<-p.stopCh
+}
+
+// Stop stops accepting new connections and waits for established
+// connections to finish
+func (p *Proxy) Stop() error {
+	p.mu.Lock()
+	p.stopped = true
+	servers := p.servers
+	p.servers = make(map[string]server)
+	p.mu.Unlock()
+
+	for _, s := range servers {
+		s.close()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for _, s := range servers {
+			s.wait()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(drainTimeout):
+		log.Warn("Timeout waiting for connections to finish, close them")
+		for _, s := range servers {
+			s.closeConns()
+		}
+		<-done
+	}
+
+	p.stopOnce.Do(func() {
+		close(p.stopCh)
+	})
+	log.Info("L4 proxy has stopped")
+	return nil
+}
+
+// Apply starts listening on new ports, stops listening on removed ports
+// and updates endpoints of the others
+func (p *Proxy) Apply(split provider.Split) error {
+	services := make(map[string]api.L4Service)
+	for _, svc := range split.HTTP {
+		if len(svc.Rules) > 0 || svc.Stickiness != nil || svc.MirrorPercent > 0 || svc.GRPC {
+			log.Warn("HTTP features are not supported by l4 proxy, the port is proxied as TCP", log.Fields{"port": svc.Port})
+		}
+		services[serverKey("tcp", svc.Port)] = api.L4Service{
+			Port:      svc.Port,
+			Backend:   svc.Backend,
+			Endpoints: svc.Endpoints,
+		}
+	}
+	for _, svc := range split.TCP {
+		services[serverKey("tcp", svc.Port)] = svc
+	}
+	for _, svc := range split.UDP {
+		services[serverKey("udp", svc.Port)] = svc
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return errServerClosed
+	}
+
+	for key, s := range p.servers {
+		if _, ok := services[key]; !ok {
+			log.Info("Stop listening", log.Fields{"server": key})
+			s.close()
+			delete(p.servers, key)
+		}
+	}
+
+	var errs []error
+	for key, svc := range services {
+		if s, ok := p.servers[key]; ok {
+			s.update(svc)
+			continue
+		}
+
+		log.Info("Start listening", log.Fields{"server": key})
+		var s server
+		var err error
+		if key == serverKey("udp", svc.Port) {
+			s, err = newUDPServer(svc)
+		} else {
+			s, err = newTCPServer(svc)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v: %v", key, err))
+			continue
+		}
+		p.servers[key] = s
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error listening on ports: %v", errs)
+	}
+	return nil
+}
+
+// Health returns the error if any port stopped serving
+func (p *Proxy) Health() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return errServerClosed
+	}
+	for key, s := range p.servers {
+		if err := s.health(); err != nil {
+			return fmt.Errorf("%v: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// Stats returns the traffic stats of each side of all ports
+func (p *Proxy) Stats() ([]provider.SideStats, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var stats []provider.SideStats
+	for _, s := range p.servers {
+		stats = append(stats, s.snapshot()...)
+	}
+	return stats, nil
+}
+
+func serverKey(network string, port int32) string {
+	return network + "/" + strconv.Itoa(int(port))
+}
+
+// listenAddress returns the address listening on all interfaces
+func listenAddress(port int32) string {
+	return ":" + strconv.Itoa(int(port))
+}
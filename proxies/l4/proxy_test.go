@@ -0,0 +1,359 @@
+package l4
+
+import (
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/caicloud/canary-release/pkg/api"
+	"github.com/caicloud/canary-release/proxies/provider"
+)
+
+// startTCPBackend starts a TCP server which replies its name to each
+// connection after reading the first bytes
+func startTCPBackend(t *testing.T, name string) (api.Endpoint, io.Closer) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 1024)
+				_, _ = conn.Read(buf)
+				_, _ = conn.Write([]byte(name))
+			}()
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	return api.Endpoint{Address: addr.IP.String(), Port: int32(addr.Port)}, l
+}
+
+// startUDPBackend starts a UDP server which replies its name to each datagram
+func startUDPBackend(t *testing.T, name string) (api.Endpoint, io.Closer) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			_, client, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteToUDP([]byte(name), client)
+		}
+	}()
+
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	return api.Endpoint{Address: addr.IP.String(), Port: int32(addr.Port)}, conn
+}
+
+// freePort returns a port which is free for both TCP and UDP
+func freePort(t *testing.T) int32 {
+	for i := 0; i < 10; i++ {
+		l, err := net.Listen("tcp", ":0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := l.Addr().(*net.TCPAddr).Port
+		_ = l.Close()
+		u, err := net.ListenPacket("udp", ":"+strconv.Itoa(port))
+		if err != nil {
+			continue
+		}
+		_ = u.Close()
+		return int32(port)
+	}
+	t.Fatal("no free port")
+	return 0
+}
+
+func newTestProxy() *Proxy {
+	p := NewProxy()
+	go p.Start()
+	return p
+}
+
+// tcpRequest sends data to the port and returns the reply
+func tcpRequest(t *testing.T, port int32, data []byte) string {
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:"+strconv.Itoa(int(port)), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(reply)
+}
+
+// clientHello returns the first bytes sent by a TLS client
+func clientHello(t *testing.T, serverName string) []byte {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		defer client.Close()
+		_ = tls.Client(client, &tls.Config{ServerName: serverName}).Handshake()
+	}()
+
+	_ = server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(server, header); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, int(header[3])<<8|int(header[4]))
+	if _, err := io.ReadFull(server, body); err != nil {
+		t.Fatal(err)
+	}
+	return append(header, body...)
+}
+
+func TestProxyTCP(t *testing.T) {
+	origin, originBackend := startTCPBackend(t, "origin")
+	defer originBackend.Close()
+	origin.Weight = 3
+	canary, canaryBackend := startTCPBackend(t, "canary")
+	defer canaryBackend.Close()
+	canary.Weight = 1
+	canary.Canary = true
+
+	port := freePort(t)
+	p := newTestProxy()
+	defer p.Stop()
+	split := provider.Split{
+		TCP: []api.L4Service{
+			{Port: port, Endpoints: []api.Endpoint{origin, canary}},
+		},
+	}
+	if err := p.Apply(split); err != nil {
+		t.Fatal(err)
+	}
+
+	count := map[string]int{}
+	for i := 0; i < 8; i++ {
+		count[tcpRequest(t, port, []byte("ping"))]++
+	}
+	if count["origin"] != 6 || count["canary"] != 2 {
+		t.Errorf("got %v, want 6 origin and 2 canary", count)
+	}
+
+	// all traffic goes to canary without restarting the listener
+	split.TCP[0].Endpoints[0].Weight = 0
+	if err := p.Apply(split); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if got := tcpRequest(t, port, []byte("ping")); got != "canary" {
+			t.Errorf("got %v, want canary", got)
+		}
+	}
+
+	if err := p.Health(); err != nil {
+		t.Errorf("Health() = %v", err)
+	}
+
+	// bytes are recorded after the connection is closed
+	var stats []provider.SideStats
+	for i := 0; i < 10; i++ {
+		stats, _ = p.Stats()
+		if len(stats) == 2 && stats[0].BytesSent+stats[1].BytesSent == 6*6+6*6 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	for _, s := range stats {
+		if s.Connections != 6 || s.BytesReceived != 6*4 || s.BytesSent != 6*6 {
+			t.Errorf("Stats() of %v = %+v, want 6 connections", s.Side, s)
+		}
+	}
+
+	// the port is closed when the service is removed
+	if err := p.Apply(provider.Split{}); err != nil {
+		t.Fatal(err)
+	}
+	if conn, err := net.DialTimeout("tcp", "127.0.0.1:"+strconv.Itoa(int(port)), time.Second); err == nil {
+		conn.Close()
+		t.Errorf("port %v is still listening", port)
+	}
+}
+
+func TestProxyTCPFailover(t *testing.T) {
+	origin, originBackend := startTCPBackend(t, "origin")
+	defer originBackend.Close()
+	origin.Weight = 1
+	canary, canaryBackend := startTCPBackend(t, "canary")
+	canaryBackend.Close()
+	canary.Weight = 1
+	canary.Canary = true
+
+	port := freePort(t)
+	p := newTestProxy()
+	defer p.Stop()
+	split := provider.Split{
+		TCP: []api.L4Service{
+			{Port: port, Endpoints: []api.Endpoint{origin, canary}},
+		},
+	}
+	if err := p.Apply(split); err != nil {
+		t.Fatal(err)
+	}
+
+	// the broken canary fails over to origin and is skipped afterwards
+	for i := 0; i < 4; i++ {
+		if got := tcpRequest(t, port, []byte("ping")); got != "origin" {
+			t.Errorf("got %v, want origin", got)
+		}
+	}
+	stats, _ := p.Stats()
+	for _, s := range stats {
+		if s.Side == provider.SideCanary && (s.Failures != 1 || s.Connections != 0) {
+			t.Errorf("Stats() of canary = %+v, want 1 failure", s)
+		}
+	}
+}
+
+func TestProxyTCPServerName(t *testing.T) {
+	origin, originBackend := startTCPBackend(t, "origin")
+	defer originBackend.Close()
+	origin.Weight = 1
+	canary, canaryBackend := startTCPBackend(t, "canary")
+	defer canaryBackend.Close()
+	canary.Canary = true
+
+	port := freePort(t)
+	p := newTestProxy()
+	defer p.Stop()
+	err := p.Apply(provider.Split{
+		TCP: []api.L4Service{
+			{
+				Port:        port,
+				Endpoints:   []api.Endpoint{origin, canary},
+				ServerNames: []string{"*.canary.example.com"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		serverName string
+		want       string
+	}{
+		{"www.canary.example.com", "canary"},
+		{"www.example.com", "origin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			if got := tcpRequest(t, port, clientHello(t, tt.serverName)); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyTCPDrained(t *testing.T) {
+	origin, originBackend := startTCPBackend(t, "origin")
+	defer originBackend.Close()
+	origin.Weight = 1
+	canary, canaryBackend := startTCPBackend(t, "canary")
+	defer canaryBackend.Close()
+	canary.Weight = 1
+	canary.Canary = true
+
+	port := freePort(t)
+	p := newTestProxy()
+	defer p.Stop()
+	err := p.Apply(provider.Split{
+		TCP: []api.L4Service{
+			{
+				Port:        port,
+				Endpoints:   []api.Endpoint{origin, canary},
+				ServerNames: []string{"*.canary.example.com"},
+				Drained:     true,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, serverName := range []string{"www.canary.example.com", "www.example.com", "www.example.com"} {
+		if got := tcpRequest(t, port, clientHello(t, serverName)); got != "origin" {
+			t.Errorf("got %v for %v, want origin", got, serverName)
+		}
+	}
+}
+
+func TestProxyUDP(t *testing.T) {
+	origin, originBackend := startUDPBackend(t, "origin")
+	defer originBackend.Close()
+	origin.Weight = 1
+	canary, canaryBackend := startUDPBackend(t, "canary")
+	defer canaryBackend.Close()
+	canary.Weight = 1
+	canary.Canary = true
+
+	port := freePort(t)
+	p := newTestProxy()
+	defer p.Stop()
+	err := p.Apply(provider.Split{
+		UDP: []api.L4Service{
+			{Port: port, Endpoints: []api.Endpoint{origin, canary}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := map[string]int{}
+	for i := 0; i < 4; i++ {
+		conn, err := net.Dial("udp", "127.0.0.1:"+strconv.Itoa(int(port)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		// datagrams of a session go to the same endpoint
+		var first string
+		for j := 0; j < 2; j++ {
+			if _, err := conn.Write([]byte("ping")); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 1024)
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if j == 0 {
+				first = string(buf[:n])
+			} else if string(buf[:n]) != first {
+				t.Errorf("session moved from %v to %v", first, string(buf[:n]))
+			}
+		}
+		count[first]++
+		conn.Close()
+	}
+	if count["origin"] != 2 || count["canary"] != 2 {
+		t.Errorf("got %v, want 2 origin and 2 canary", count)
+	}
+}
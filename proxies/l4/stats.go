@@ -0,0 +1,96 @@
+package l4
+
+import (
+	"sync"
+	"time"
+
+	"github.com/caicloud/canary-release/pkg/api"
+	"github.com/caicloud/canary-release/proxies/provider"
+)
+
+// serverStats counts the traffic of both sides of a server,
+// it's safe for concurrent use
+type serverStats struct {
+	mu     sync.Mutex
+	origin provider.SideStats
+	canary provider.SideStats
+}
+
+func newServerStats(backend api.L4Backend) *serverStats {
+	s := &serverStats{}
+	s.origin = provider.SideStats{Side: provider.SideOrigin}
+	s.canary = provider.SideStats{Side: provider.SideCanary}
+	s.setBackend(backend)
+	return s
+}
+
+// setBackend sets the service labels of the stats
+func (s *serverStats) setBackend(backend api.L4Backend) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.origin.Service, s.origin.Port = backend.Name, backend.Port
+	s.canary.Service, s.canary.Port = backend.Name, backend.Port
+}
+
+// record updates the stats of the side
+func (s *serverStats) record(canary bool, f func(*provider.SideStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if canary {
+		f(&s.canary)
+		return
+	}
+	f(&s.origin)
+}
+
+// connected records a new connection and the time connecting to the endpoint
+func (s *serverStats) connected(canary bool, latency time.Duration, err error) {
+	s.record(canary, func(stats *provider.SideStats) {
+		stats.Connections++
+		if err != nil {
+			stats.Errors++
+			return
+		}
+		stats.Latency.Observe(latency.Seconds())
+	})
+}
+
+// failed records a failed try to connect to an endpoint
+func (s *serverStats) failed(canary bool) {
+	s.record(canary, func(stats *provider.SideStats) {
+		stats.Failures++
+	})
+}
+
+// opened records a connection in progress
+func (s *serverStats) opened(canary bool) {
+	s.record(canary, func(stats *provider.SideStats) {
+		stats.Active++
+	})
+}
+
+// closed records the end of a connection in progress
+func (s *serverStats) closed(canary bool) {
+	s.record(canary, func(stats *provider.SideStats) {
+		stats.Active--
+	})
+}
+
+// transferred records the bytes of a connection
+func (s *serverStats) transferred(canary bool, received, sent int64) {
+	s.record(canary, func(stats *provider.SideStats) {
+		stats.BytesReceived += uint64(received)
+		stats.BytesSent += uint64(sent)
+	})
+}
+
+// snapshot returns a copy of the stats
+func (s *serverStats) snapshot() []provider.SideStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := []provider.SideStats{s.origin, s.canary}
+	for i := range ret {
+		ret[i].Latency.Buckets = append([]uint64(nil), ret[i].Latency.Buckets...)
+	}
+	return ret
+}
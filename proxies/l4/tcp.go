@@ -0,0 +1,285 @@
+package l4
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/caicloud/canary-release/pkg/api"
+	"github.com/caicloud/canary-release/proxies/provider"
+	log "github.com/zoumo/logdog"
+)
+
+const (
+	// dialTimeout is the timeout of connecting to an endpoint
+	dialTimeout = 5 * time.Second
+	// clientHelloTimeout is the timeout of reading TLS ClientHello
+	clientHelloTimeout = 5 * time.Second
+)
+
+var errClientHelloRead = errors.New("ClientHello has been read")
+
+// tcpServer proxies TCP connections of a port to the endpoints
+type tcpServer struct {
+	port     int32
+	listener net.Listener
+
+	mu          sync.RWMutex
+	weighted    *balancer
+	origin      *balancer
+	canary      *balancer
+	serverNames []string
+	serveErr    error
+	closed      bool
+	conns       map[net.Conn]struct{}
+
+	stats    *serverStats
+	failures *endpointFailures
+	wg       sync.WaitGroup
+}
+
+// newTCPServer listens on the port of the service and starts serving
+func newTCPServer(svc api.L4Service) (*tcpServer, error) {
+	listener, err := net.Listen("tcp", listenAddress(svc.Port))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &tcpServer{
+		port:     svc.Port,
+		listener: listener,
+		conns:    make(map[net.Conn]struct{}),
+		stats:    newServerStats(svc.Backend),
+		failures: newEndpointFailures(),
+	}
+	s.update(svc)
+
+	go s.serve()
+	return s, nil
+}
+
+// update replaces the endpoints of the server, established connections
+// are not affected
+func (s *tcpServer) update(svc api.L4Service) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.weighted, s.origin, s.canary = newBalancers(svc, s.failures)
+	s.serverNames = svc.ServerNames
+	s.stats.setBackend(svc.Backend)
+}
+
+// close stops accepting new connections
+func (s *tcpServer) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	_ = s.listener.Close()
+}
+
+// closeConns closes all established connections
+func (s *tcpServer) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+}
+
+// wait waits for all established connections to finish
+func (s *tcpServer) wait() {
+	s.wg.Wait()
+}
+
+// snapshot returns the traffic stats of the server
+func (s *tcpServer) snapshot() []provider.SideStats {
+	return s.stats.snapshot()
+}
+
+// health returns the error if the server stopped unexpectedly
+func (s *tcpServer) health() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.serveErr
+}
+
+func (s *tcpServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			s.mu.Lock()
+			if !s.closed {
+				log.Error("Error accept tcp connection", log.Fields{"port": s.port, "err": err})
+				s.serveErr = err
+			}
+			s.mu.Unlock()
+			return
+		}
+
+		if !s.track(conn) {
+			_ = conn.Close()
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+// track records the connection, it returns false if the server is closed
+func (s *tcpServer) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *tcpServer) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+func (s *tcpServer) handle(conn net.Conn) {
+	defer s.untrack(conn)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	s.mu.RLock()
+	b := s.weighted
+	origin := s.origin
+	canary := s.canary
+	serverNames := s.serverNames
+	s.mu.RUnlock()
+
+	// client sends data first in TLS, the bytes read for routing are
+	// replayed to the endpoint
+	var client io.Reader = conn
+	if len(serverNames) > 0 {
+		serverName, hello := readServerName(conn)
+		client = io.MultiReader(bytes.NewReader(hello), conn)
+		if matchServerName(serverName, serverNames) {
+			b = canary
+		}
+	}
+
+	ip := clientIP(conn.RemoteAddr())
+	ep, ok := b.pick(ip)
+	if !ok {
+		log.Warn("No endpoints for tcp connection", log.Fields{"port": s.port})
+		return
+	}
+
+	start := time.Now()
+	upstream, err := s.dial(ep)
+	// connections to canary by server names don't fail over to origin
+	if err != nil && ep.Canary && b != canary {
+		if oep, ok := origin.pick(ip); ok {
+			ep = oep
+			upstream, err = s.dial(ep)
+		}
+	}
+	s.stats.connected(ep.Canary, time.Since(start), err)
+	if err != nil {
+		log.Error("Error connect to endpoint", log.Fields{"port": s.port, "endpoint": endpointAddress(ep), "err": err})
+		return
+	}
+
+	s.stats.opened(ep.Canary)
+	received, sent := pipe(conn, client, upstream)
+	s.stats.closed(ep.Canary)
+	s.stats.transferred(ep.Canary, received, sent)
+}
+
+// dial connects to the endpoint, the failure is recorded
+// and the endpoint is skipped for a while
+func (s *tcpServer) dial(ep api.Endpoint) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", endpointAddress(ep), dialTimeout)
+	if err != nil {
+		s.failures.fail(ep)
+		s.stats.failed(ep.Canary)
+	}
+	return conn, err
+}
+
+// pipe copies data in both directions until either side is closed,
+// half-closed connections are not kept like nginx does by default.
+// Both connections are closed when it returns.
+func pipe(conn net.Conn, client io.Reader, upstream net.Conn) (received, sent int64) {
+	done := make(chan struct{}, 2)
+	go func() {
+		received, _ = io.Copy(upstream, client)
+		done <- struct{}{}
+	}()
+	go func() {
+		sent, _ = io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+
+	// stop the other direction
+	_ = conn.Close()
+	_ = upstream.Close()
+	<-done
+	return received, sent
+}
+
+// readServerName reads the TLS ClientHello from the connection and returns
+// the server name with the bytes read. The server name is empty if the
+// client doesn't speak TLS or sends no SNI.
+func readServerName(conn net.Conn) (string, []byte) {
+	var buf bytes.Buffer
+	var serverName string
+
+	_ = conn.SetReadDeadline(time.Now().Add(clientHelloTimeout))
+	// the handshake is aborted as soon as the ClientHello is parsed
+	_ = tls.Server(sniffConn{Conn: conn, r: io.TeeReader(conn, &buf)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errClientHelloRead
+		},
+	}).Handshake()
+	_ = conn.SetReadDeadline(time.Time{})
+
+	return serverName, buf.Bytes()
+}
+
+// sniffConn is a read-only connection, nothing is written to the client
+type sniffConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c sniffConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c sniffConn) Write(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+// clientIP returns the IP of the address
+func clientIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP.String()
+	case *net.UDPAddr:
+		return a.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
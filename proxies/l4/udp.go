@@ -0,0 +1,216 @@
+package l4
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/caicloud/canary-release/pkg/api"
+	"github.com/caicloud/canary-release/proxies/provider"
+	log "github.com/zoumo/logdog"
+)
+
+const (
+	// udpSessionTimeout is the idle timeout of a UDP session
+	udpSessionTimeout = 30 * time.Second
+	// maxDatagramSize is the max size of a UDP datagram
+	maxDatagramSize = 64 * 1024
+)
+
+// udpServer proxies UDP datagrams of a port to the endpoints. Datagrams
+// from the same client address form a session which goes to one endpoint
+// until it is idle for udpSessionTimeout.
+type udpServer struct {
+	port int32
+	conn *net.UDPConn
+
+	mu       sync.RWMutex
+	weighted *balancer
+	sessions map[string]*udpSession
+	serveErr error
+	closed   bool
+
+	stats *serverStats
+	wg    sync.WaitGroup
+}
+
+// udpSession is the connection to the endpoint picked for a client
+type udpSession struct {
+	upstream *net.UDPConn
+	canary   bool
+}
+
+// newUDPServer listens on the port of the service and starts serving
+func newUDPServer(svc api.L4Service) (*udpServer, error) {
+	addr, err := net.ResolveUDPAddr("udp", listenAddress(svc.Port))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &udpServer{
+		port:     svc.Port,
+		conn:     conn,
+		sessions: make(map[string]*udpSession),
+		stats:    newServerStats(svc.Backend),
+	}
+	s.update(svc)
+
+	go s.serve()
+	return s, nil
+}
+
+// update replaces the endpoints of the server, established sessions
+// are not affected
+func (s *udpServer) update(svc api.L4Service) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// connecting UDP never fails, so no endpoint is considered down
+	s.weighted, _, _ = newBalancers(svc, nil)
+	s.stats.setBackend(svc.Backend)
+}
+
+// close stops receiving datagrams, sessions are closed at once because
+// replies can't be sent without the listening socket
+func (s *udpServer) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	_ = s.conn.Close()
+	s.closeConns()
+}
+
+// closeConns closes all sessions
+func (s *udpServer) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, session := range s.sessions {
+		_ = session.upstream.Close()
+	}
+}
+
+// wait waits for all sessions to finish
+func (s *udpServer) wait() {
+	s.wg.Wait()
+}
+
+// snapshot returns the traffic stats of the server
+func (s *udpServer) snapshot() []provider.SideStats {
+	return s.stats.snapshot()
+}
+
+// health returns the error if the server stopped unexpectedly
+func (s *udpServer) health() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.serveErr
+}
+
+func (s *udpServer) serve() {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, client, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			s.mu.Lock()
+			if !s.closed {
+				log.Error("Error read udp datagram", log.Fields{"port": s.port, "err": err})
+				s.serveErr = err
+			}
+			s.mu.Unlock()
+			return
+		}
+
+		session, err := s.session(client)
+		if err != nil {
+			log.Error("Error create udp session", log.Fields{"port": s.port, "client": client.String(), "err": err})
+			continue
+		}
+		s.stats.transferred(session.canary, int64(n), 0)
+		_ = session.upstream.SetReadDeadline(time.Now().Add(udpSessionTimeout))
+		_, err = session.upstream.Write(buf[:n])
+		if err != nil {
+			log.Warn("Error send udp datagram to endpoint", log.Fields{"port": s.port, "endpoint": session.upstream.RemoteAddr().String(), "err": err})
+		}
+	}
+}
+
+// session returns the session of the client, a new endpoint
+// is picked if the client has no session
+func (s *udpServer) session(client *net.UDPAddr) (*udpSession, error) {
+	key := client.String()
+
+	s.mu.RLock()
+	session, ok := s.sessions[key]
+	b := s.weighted
+	s.mu.RUnlock()
+	if ok {
+		return session, nil
+	}
+
+	ep, ok := b.pick(clientIP(client))
+	if !ok {
+		return nil, errNoEndpoints
+	}
+	start := time.Now()
+	upstream, err := dialUDP(endpointAddress(ep))
+	s.stats.connected(ep.Canary, time.Since(start), err)
+	if err != nil {
+		return nil, err
+	}
+	session = &udpSession{upstream: upstream, canary: ep.Canary}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = upstream.Close()
+		return nil, errServerClosed
+	}
+	s.sessions[key] = session
+	s.wg.Add(1)
+	s.mu.Unlock()
+	s.stats.opened(session.canary)
+
+	go s.reply(key, client, session)
+	return session, nil
+}
+
+// reply sends the datagrams from the endpoint back to the client
+// until the session is idle
+func (s *udpServer) reply(key string, client *net.UDPAddr, session *udpSession) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.sessions, key)
+		s.mu.Unlock()
+		_ = session.upstream.Close()
+		s.stats.closed(session.canary)
+		s.wg.Done()
+	}()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, err := session.upstream.Read(buf)
+		if err != nil {
+			return
+		}
+		_ = session.upstream.SetReadDeadline(time.Now().Add(udpSessionTimeout))
+		_, err = s.conn.WriteToUDP(buf[:n], client)
+		if err != nil {
+			return
+		}
+		s.stats.transferred(session.canary, 0, int64(n))
+	}
+}
+
+func dialUDP(address string) (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return net.DialUDP("udp", nil, addr)
+}
@@ -16,6 +16,14 @@ type Configuration struct {
 	CanaryReleaseName      string
 	CanaryReleaseNamespace string
 	ReleaseName            string
+	// ConfigMap is the ConfigMap in namespace/name format to tune the proxy,
+	// the controller sets the one of the canary release or the default one
+	ConfigMap string
+	// LeaderElect enables leader election among replicas of the proxy,
+	// only the leader changes resources in cluster
+	LeaderElect bool
+	// PodName is the identity of the proxy in leader election
+	PodName string
 }
 
 // AddFlags add flags to app
@@ -40,6 +48,24 @@ func (c *Configuration) AddFlags(app *cli.App) {
 			EnvVar:      "RELEASE_NAME",
 			Destination: &c.ReleaseName,
 		},
+		cli.StringFlag{
+			Name:        "proxy-configmap",
+			Usage:       "the configmap of proxy in namespace/name format",
+			EnvVar:      "PROXY_CONFIGMAP",
+			Destination: &c.ConfigMap,
+		},
+		cli.BoolTFlag{
+			Name:        "leader-elect",
+			Usage:       "elect a leader among replicas of proxy to change resources in cluster",
+			EnvVar:      "LEADER_ELECT",
+			Destination: &c.LeaderElect,
+		},
+		cli.StringFlag{
+			Name:        "pod-name",
+			Usage:       "the name of proxy pod, defaults to hostname",
+			EnvVar:      "POD_NAME",
+			Destination: &c.PodName,
+		},
 	}
 
 	app.Flags = append(app.Flags, flags...)
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	nginx "github.com/caicloud/canary-release/third_party/ingress/controllers/nginx/pkg/config"
+	log "github.com/zoumo/logdog"
+)
+
+// NewTemplateConfig returns the default template config tuned by the data
+// of a ConfigMap, see ReadConfig
+func NewTemplateConfig(data map[string]string) (TemplateConfig, error) {
+	cfg := NewDefaultTemplateConfig()
+	err := ReadConfig(&cfg.Cfg, data)
+	return cfg, err
+}
+
+// ReadConfig overrides the nginx configuration by the data of a ConfigMap.
+// Keys are the json tags of the configuration, items of lists are separated
+// by commas. Unknown keys and invalid values are ignored, so a typo doesn't
+// stop the proxy from applying traffic splits.
+func ReadConfig(cfg *nginx.Configuration, data map[string]string) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	types := make(map[string]reflect.Type)
+	fieldTypes(reflect.TypeOf(*cfg), types)
+
+	values := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		typ, ok := types[key]
+		if !ok {
+			log.Warn("Unknown nginx configuration, ignore it", log.Fields{"key": key})
+			continue
+		}
+		v, err := parseValue(typ, strings.TrimSpace(value))
+		if err != nil {
+			log.Warn("Invalid nginx configuration, use the default", log.Fields{"key": key, "value": value, "err": err})
+			continue
+		}
+		values[key] = v
+	}
+
+	raw, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, cfg)
+}
+
+// fieldTypes collects the types of fields by json names,
+// including the fields of embedded structs
+func fieldTypes(typ reflect.Type, types map[string]reflect.Type) {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			fieldTypes(field.Type, types)
+			continue
+		}
+		if name == "" || name == "-" {
+			continue
+		}
+		types[name] = field.Type
+	}
+}
+
+// parseValue converts the string to a value which is encoded
+// to the json of the type
+func parseValue(typ reflect.Type, value string) (interface{}, error) {
+	switch typ.Kind() {
+	case reflect.Bool:
+		return strconv.ParseBool(value)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(value, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(value, 64)
+	case reflect.String:
+		return value, nil
+	case reflect.Slice:
+		items := []interface{}{}
+		if value == "" {
+			return items, nil
+		}
+		for _, item := range strings.Split(value, ",") {
+			v, err := parseValue(typ.Elem(), strings.TrimSpace(item))
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %v", typ)
+	}
+}
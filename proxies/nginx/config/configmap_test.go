@@ -0,0 +1,63 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string]string
+		check   func(cfg TemplateConfig) bool
+		wantErr bool
+	}{
+		{
+			name: "string and int",
+			data: map[string]string{"load-balance": "ewma", "upstream-keepalive-connections": "32", "worker-processes": "2"},
+			check: func(cfg TemplateConfig) bool {
+				return cfg.Cfg.LoadBalanceAlgorithm == "ewma" && cfg.Cfg.UpstreamKeepaliveConnections == 32 && cfg.Cfg.WorkerProcesses == "2"
+			},
+		},
+		{
+			name: "embedded backend and bool",
+			data: map[string]string{"proxy-read-timeout": "120", "use-gzip": "false"},
+			check: func(cfg TemplateConfig) bool {
+				return cfg.Cfg.ProxyReadTimeout == 120 && !cfg.Cfg.UseGzip && cfg.Cfg.ProxyConnectTimeout == 5
+			},
+		},
+		{
+			name: "lists",
+			data: map[string]string{"proxy-real-ip-cidr": "10.0.0.0/8, 172.16.0.0/12", "custom-http-errors": "502,503"},
+			check: func(cfg TemplateConfig) bool {
+				return reflect.DeepEqual(cfg.Cfg.ProxyRealIPCIDR, []string{"10.0.0.0/8", "172.16.0.0/12"}) &&
+					reflect.DeepEqual(cfg.Cfg.CustomHTTPErrors, []int{502, 503})
+			},
+		},
+		{
+			name: "unknown key",
+			data: map[string]string{"no-such-key": "1"},
+			check: func(cfg TemplateConfig) bool {
+				return reflect.DeepEqual(cfg, NewDefaultTemplateConfig())
+			},
+		},
+		{
+			name: "invalid value",
+			data: map[string]string{"proxy-read-timeout": "1m", "use-gzip": "false"},
+			check: func(cfg TemplateConfig) bool {
+				return cfg.Cfg.ProxyReadTimeout == NewDefaultTemplateConfig().Cfg.ProxyReadTimeout && !cfg.Cfg.UseGzip
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := NewTemplateConfig(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewTemplateConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !tt.check(cfg) {
+				t.Errorf("NewTemplateConfig() = %+v", cfg.Cfg)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"reflect"
+
 	"github.com/caicloud/canary-release/pkg/api"
 	nginx "github.com/caicloud/canary-release/third_party/ingress/controllers/nginx/pkg/config"
 )
@@ -10,13 +12,19 @@ type TemplateConfig struct {
 	BacklogSize   int
 	IsIPV6Enabled bool
 	Cfg           nginx.Configuration
+	HTTPBackends  []api.HTTPService
 	TCPBackends   []api.L4Service
 	UDPBackends   []api.L4Service
 }
 
 func NewDefaultTemplateConfig() TemplateConfig {
+	cfg := nginx.NewDefault()
+	// an endpoint failing to connect is skipped for a while,
+	// so a broken canary doesn't fail its share of traffic
+	cfg.UpstreamMaxFails = 1
+	cfg.UpstreamFailTimeout = 10
 	return TemplateConfig{
-		Cfg: nginx.NewDefault(),
+		Cfg: cfg,
 	}
 }
 
@@ -29,6 +37,30 @@ func (c *TemplateConfig) Equal(c2 *TemplateConfig) bool {
 		return false
 	}
 
+	if c.MaxOpenFiles != c2.MaxOpenFiles ||
+		c.BacklogSize != c2.BacklogSize ||
+		c.IsIPV6Enabled != c2.IsIPV6Enabled ||
+		!reflect.DeepEqual(c.Cfg, c2.Cfg) {
+		return false
+	}
+
+	if len(c.HTTPBackends) != len(c2.HTTPBackends) {
+		return false
+	}
+
+	for _, c1b := range c.HTTPBackends {
+		found := false
+		for _, c2b := range c2.HTTPBackends {
+			if c1b.Equal(c2b) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
 	if len(c.TCPBackends) != len(c2.TCPBackends) {
 		return false
 	}
@@ -65,3 +97,118 @@ func (c *TemplateConfig) Equal(c2 *TemplateConfig) bool {
 
 	return true
 }
+
+// EqualIgnoreEndpoints tests for equality between two Template Config types
+// without comparing the endpoints of backends. If it is true, the changes can
+// be applied to the running nginx without reloading.
+func (c *TemplateConfig) EqualIgnoreEndpoints(c2 *TemplateConfig) bool {
+	if c == c2 {
+		return true
+	}
+	if c == nil || c2 == nil {
+		return false
+	}
+
+	c1s := c.withoutEndpoints()
+	c2s := c2.withoutEndpoints()
+	return c1s.Equal(&c2s)
+}
+
+func (c *TemplateConfig) withoutEndpoints() TemplateConfig {
+	ret := *c
+	ret.HTTPBackends = make([]api.HTTPService, 0, len(c.HTTPBackends))
+	for _, s := range c.HTTPBackends {
+		s.Endpoints = nil
+		s.Drained = false
+		// the weight of new sticky clients is applied by the lua balancer
+		if s.Stickiness != nil {
+			stickiness := *s.Stickiness
+			stickiness.CanaryWeight = 0
+			s.Stickiness = &stickiness
+		}
+		ret.HTTPBackends = append(ret.HTTPBackends, s)
+	}
+	ret.TCPBackends = make([]api.L4Service, 0, len(c.TCPBackends))
+	for _, s := range c.TCPBackends {
+		s.Endpoints = nil
+		s.Drained = false
+		ret.TCPBackends = append(ret.TCPBackends, s)
+	}
+	ret.UDPBackends = make([]api.L4Service, 0, len(c.UDPBackends))
+	for _, s := range c.UDPBackends {
+		s.Endpoints = nil
+		s.Drained = false
+		ret.UDPBackends = append(ret.UDPBackends, s)
+	}
+	return ret
+}
+
+// HTTPDynamicBackends returns all upstreams in http block
+// whose endpoints are configured without reloading
+func (c *TemplateConfig) HTTPDynamicBackends() []api.Backend {
+	var backends []api.Backend
+	for _, s := range c.HTTPBackends {
+		sides := c.sideBackends(s.UpstreamName(), "", s.Endpoints)
+		if s.Stickiness != nil {
+			sides[0].CanaryWeight = s.Stickiness.CanaryWeight
+		}
+		if s.Drained {
+			// mirrored requests are dropped instead of being sent to origin twice
+			drainSides(sides, s.MirrorPercent == 0)
+		}
+		backends = append(backends, sides...)
+	}
+	return backends
+}
+
+// StreamDynamicBackends returns all upstreams in stream block
+// whose endpoints are configured without reloading
+func (c *TemplateConfig) StreamDynamicBackends() []api.Backend {
+	var backends []api.Backend
+	for _, s := range c.TCPBackends {
+		backends = append(backends, c.streamSideBackends(s)...)
+	}
+	for _, s := range c.UDPBackends {
+		backends = append(backends, c.streamSideBackends(s)...)
+	}
+	return backends
+}
+
+// streamSideBackends returns the side upstreams of a stream service
+func (c *TemplateConfig) streamSideBackends(s api.L4Service) []api.Backend {
+	sides := c.sideBackends(s.UpstreamName(), s.HashKey, s.Endpoints)
+	if s.Drained {
+		drainSides(sides, true)
+	}
+	return sides
+}
+
+// drainSides empties the canary upstream of the side upstreams returned by
+// sideBackends, its traffic goes to origin endpoints if redirect is true
+func drainSides(sides []api.Backend, redirect bool) {
+	sides[2].Endpoints = nil
+	if redirect {
+		sides[2].Endpoints = sides[1].Endpoints
+	}
+}
+
+// sideBackends returns the weighted upstream and the upstreams of each side,
+// endpoints in a side upstream share the traffic equally
+func (c *TemplateConfig) sideBackends(name, hashKey string, endpoints []api.Endpoint) []api.Backend {
+	maxFails, failTimeout := c.Cfg.UpstreamMaxFails, c.Cfg.UpstreamFailTimeout
+	weighted := api.Backend{Name: name, HashKey: hashKey, MaxFails: maxFails, FailTimeout: failTimeout}
+	origin := api.Backend{Name: name + "-origin", MaxFails: maxFails, FailTimeout: failTimeout}
+	canary := api.Backend{Name: name + "-canary", MaxFails: maxFails, FailTimeout: failTimeout}
+	for _, ep := range endpoints {
+		if ep.Weight > 0 {
+			weighted.Endpoints = append(weighted.Endpoints, ep)
+		}
+		ep.Weight = 1
+		if ep.Canary {
+			canary.Endpoints = append(canary.Endpoints, ep)
+		} else {
+			origin.Endpoints = append(origin.Endpoints, ep)
+		}
+	}
+	return []api.Backend{weighted, origin, canary}
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/caicloud/canary-release/pkg/api"
+	core "k8s.io/api/core/v1"
+)
+
+func newTestConfig() TemplateConfig {
+	cfg := NewDefaultTemplateConfig()
+	cfg.HTTPBackends = []api.HTTPService{
+		{
+			Port:    8080,
+			Backend: api.L4Backend{Port: 80, Name: "web", Namespace: "test", Protocol: core.ProtocolTCP},
+			Endpoints: []api.Endpoint{
+				{Address: "10.0.0.1", Port: 80, Weight: 9},
+				{Address: "10.0.1.1", Port: 80, Weight: 1, Canary: true},
+			},
+			Rules:      []api.HTTPRule{{Header: "X-Canary", Value: "always"}},
+			Stickiness: &api.HTTPStickiness{Cookie: "canary", CanaryWeight: 10},
+		},
+	}
+	cfg.TCPBackends = []api.L4Service{
+		{
+			Port:    8081,
+			Backend: api.L4Backend{Port: 3306, Name: "db", Namespace: "test", Protocol: core.ProtocolTCP},
+			Endpoints: []api.Endpoint{
+				{Address: "10.0.0.2", Port: 3306, Weight: 1},
+				{Address: "10.0.1.2", Port: 3306, Weight: 0, Canary: true},
+			},
+			HashKey: "$remote_addr",
+		},
+	}
+	return cfg
+}
+
+func TestEqualIgnoreEndpoints(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(cfg *TemplateConfig)
+		// reload is true if the change can't be applied dynamically
+		reload bool
+	}{
+		{"nothing", func(cfg *TemplateConfig) {}, false},
+		{"weights", func(cfg *TemplateConfig) {
+			cfg.HTTPBackends[0].Endpoints[0].Weight = 5
+			cfg.HTTPBackends[0].Endpoints[1].Weight = 5
+			cfg.HTTPBackends[0].Stickiness.CanaryWeight = 50
+		}, false},
+		{"endpoints", func(cfg *TemplateConfig) {
+			cfg.TCPBackends[0].Endpoints = append(cfg.TCPBackends[0].Endpoints, api.Endpoint{Address: "10.0.0.3", Port: 3306, Weight: 1})
+		}, false},
+		{"drained", func(cfg *TemplateConfig) {
+			cfg.HTTPBackends[0].Drained = true
+			cfg.TCPBackends[0].Drained = true
+		}, false},
+		{"rules", func(cfg *TemplateConfig) {
+			cfg.HTTPBackends[0].Rules = nil
+		}, true},
+		{"cookie", func(cfg *TemplateConfig) {
+			cfg.HTTPBackends[0].Stickiness.Cookie = "other"
+		}, true},
+		{"hash key", func(cfg *TemplateConfig) {
+			cfg.TCPBackends[0].HashKey = ""
+		}, true},
+		{"server names", func(cfg *TemplateConfig) {
+			cfg.TCPBackends[0].ServerNames = []string{"canary.example.com"}
+		}, true},
+		{"new backend", func(cfg *TemplateConfig) {
+			cfg.UDPBackends = append(cfg.UDPBackends, api.L4Service{Port: 8082})
+		}, true},
+		{"options", func(cfg *TemplateConfig) {
+			cfg.Cfg.KeepAlive = 30
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			running, cfg := newTestConfig(), newTestConfig()
+			tt.change(&cfg)
+			if got := running.EqualIgnoreEndpoints(&cfg); got == tt.reload {
+				t.Errorf("EqualIgnoreEndpoints() = %v, want %v", got, !tt.reload)
+			}
+		})
+	}
+}
+
+func TestDynamicBackends(t *testing.T) {
+	cfg := newTestConfig()
+
+	http := cfg.HTTPDynamicBackends()
+	maxFails, failTimeout := cfg.Cfg.UpstreamMaxFails, cfg.Cfg.UpstreamFailTimeout
+	wantHTTP := []api.Backend{
+		{
+			Name: "http-8080-test-web-80", CanaryWeight: 10, MaxFails: maxFails, FailTimeout: failTimeout,
+			Endpoints: cfg.HTTPBackends[0].Endpoints,
+		},
+		{
+			Name: "http-8080-test-web-80-origin", MaxFails: maxFails, FailTimeout: failTimeout,
+			Endpoints: []api.Endpoint{{Address: "10.0.0.1", Port: 80, Weight: 1}},
+		},
+		{
+			Name: "http-8080-test-web-80-canary", MaxFails: maxFails, FailTimeout: failTimeout,
+			Endpoints: []api.Endpoint{{Address: "10.0.1.1", Port: 80, Weight: 1, Canary: true}},
+		},
+	}
+	if !reflect.DeepEqual(http, wantHTTP) {
+		t.Errorf("HTTPDynamicBackends() = %+v, want %+v", http, wantHTTP)
+	}
+
+	stream := cfg.StreamDynamicBackends()
+	wantStream := []api.Backend{
+		{
+			// endpoints without weight are left out of the weighted upstream
+			Name: "tcp-8081-test-db-3306", HashKey: "$remote_addr", MaxFails: maxFails, FailTimeout: failTimeout,
+			Endpoints: []api.Endpoint{{Address: "10.0.0.2", Port: 3306, Weight: 1}},
+		},
+		{
+			Name: "tcp-8081-test-db-3306-origin", MaxFails: maxFails, FailTimeout: failTimeout,
+			Endpoints: []api.Endpoint{{Address: "10.0.0.2", Port: 3306, Weight: 1}},
+		},
+		{
+			Name: "tcp-8081-test-db-3306-canary", MaxFails: maxFails, FailTimeout: failTimeout,
+			Endpoints: []api.Endpoint{{Address: "10.0.1.2", Port: 3306, Weight: 1, Canary: true}},
+		},
+	}
+	if !reflect.DeepEqual(stream, wantStream) {
+		t.Errorf("StreamDynamicBackends() = %+v, want %+v", stream, wantStream)
+	}
+}
+
+func TestDrainedDynamicBackends(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.HTTPBackends = append(cfg.HTTPBackends, cfg.HTTPBackends[0])
+	cfg.HTTPBackends[1].Port = 8082
+	cfg.HTTPBackends[1].MirrorPercent = 10
+	for i := range cfg.HTTPBackends {
+		cfg.HTTPBackends[i].Drained = true
+	}
+	cfg.TCPBackends[0].Drained = true
+
+	origin := func(backends []api.Backend, i int) []api.Endpoint {
+		return backends[i*3+1].Endpoints
+	}
+	canary := func(backends []api.Backend, i int) []api.Endpoint {
+		return backends[i*3+2].Endpoints
+	}
+
+	http := cfg.HTTPDynamicBackends()
+	if got, want := canary(http, 0), origin(http, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("canary upstream of drained service = %+v, want origin endpoints %+v", got, want)
+	}
+	if got := canary(http, 1); got != nil {
+		t.Errorf("canary upstream of drained mirror service = %+v, want no endpoints", got)
+	}
+	stream := cfg.StreamDynamicBackends()
+	if got, want := canary(stream, 0), origin(stream, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("canary upstream of drained stream service = %+v, want origin endpoints %+v", got, want)
+	}
+}
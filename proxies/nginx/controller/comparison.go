@@ -0,0 +1,206 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/caicloud/canary-release/pkg/api"
+	"github.com/caicloud/canary-release/pkg/util"
+	"github.com/caicloud/canary-release/proxies/provider"
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+	log "github.com/zoumo/logdog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// comparisonCheckPeriod is the period of checking whether a comparison is due
+	comparisonCheckPeriod = 10 * time.Second
+	// defaultComparisonInterval is the interval between comparisons if it's not set in analysis
+	defaultComparisonInterval = 60 * time.Second
+	// defaultMinRequests is the least requests to canary to compare if it's not set
+	defaultMinRequests = 20
+)
+
+// sideTraffic is the traffic of a side of all ports in an interval
+type sideTraffic struct {
+	// requests and connections
+	requests uint64
+	// errors and failed tries
+	errors       uint64
+	latencySum   float64
+	latencyCount uint64
+}
+
+// errorRate returns the percentage of errors
+func (t sideTraffic) errorRate() float64 {
+	if t.requests == 0 {
+		return 0
+	}
+	return float64(t.errors) / float64(t.requests) * 100
+}
+
+// latency returns the mean latency in seconds
+func (t sideTraffic) latency() float64 {
+	if t.latencyCount == 0 {
+		return 0
+	}
+	return t.latencySum / float64(t.latencyCount)
+}
+
+// status returns the traffic in status
+func (t sideTraffic) status() releaseapi.CanarySideStatus {
+	status := releaseapi.CanarySideStatus{Requests: int64(t.requests)}
+	if t.requests > 0 {
+		status.ErrorRate = fmt.Sprintf("%.2f%%", t.errorRate())
+	}
+	if t.latencyCount > 0 {
+		status.Latency = time.Duration(t.latency() * float64(time.Second)).Round(time.Microsecond).String()
+	}
+	return status
+}
+
+// checkComparison compares the traffic of canary and origin in every interval
+// of analysis. Canary is deprecated if its error rate or latency is worse than
+// origin by more than the margins. All replicas keep the stats of the last
+// interval, but only the leader compares, so a new leader compares its own
+// traffic from the next interval. Paused canary releases are not compared,
+// and the comparison starts over after resuming.
+func (p *Proxy) checkComparison() {
+	collector, ok := p.provider.(provider.StatsCollector)
+	if !ok {
+		return
+	}
+	cr, err := p.crLister.CanaryReleases(p.namespace).Get(p.canaryrelease)
+	if err != nil || p.canaryFiltered(cr) || cr.DeletionTimestamp != nil {
+		return
+	}
+	if cr.Spec.Paused {
+		p.comparedStats = nil
+		return
+	}
+	strategy := cr.Spec.Strategy
+	if strategy == nil || strategy.Analysis == nil || strategy.Analysis.Comparison == nil {
+		return
+	}
+
+	interval := defaultComparisonInterval
+	if strategy.Analysis.IntervalSeconds > 0 {
+		interval = time.Duration(strategy.Analysis.IntervalSeconds) * time.Second
+	}
+	now := time.Now()
+	if now.Sub(p.comparedTime) < interval {
+		return
+	}
+	stats, err := collector.Stats()
+	if err != nil {
+		log.Debug("Error get traffic stats", log.Fields{"err": err})
+		return
+	}
+	last := p.comparedStats
+	p.comparedStats, p.comparedTime = stats, now
+	if last == nil || !p.isLeader() {
+		// the stats are the base of the next comparison
+		return
+	}
+
+	origin, canary := trafficSince(last, stats)
+	status := compare(strategy.Analysis.Comparison, origin, canary)
+	status.LastEvaluationTime = metav1.NewTime(now.Truncate(time.Second))
+
+	_, err = util.UpdateCRWithRetries(
+		p.cfg.Client.ReleaseV1alpha1().CanaryReleases(p.namespace),
+		p.crLister,
+		cr.Namespace,
+		cr.Name,
+		func(cr *releaseapi.CanaryRelease) error {
+			cr.Status.Comparison = &status
+			if status.Phase == releaseapi.CanaryMetricFailed {
+				cr.Status.Conditions = append(cr.Status.Conditions, api.NewCondition(api.ReasonComparisonFailed, status.Message))
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		log.Error("Error update comparison status", log.Fields{"cr": p.canaryrelease, "err": err})
+		return
+	}
+
+	if status.Phase == releaseapi.CanaryMetricFailed {
+		log.Warn("Canary is worse than origin, deprecate this CanaryRelease", log.Fields{"cr.name": cr.Name, "cr.ns": cr.Namespace, "message": status.Message})
+		if err := p.deprecate(cr); err != nil {
+			log.Error("Error deprecate canary release", log.Fields{"cr": p.canaryrelease, "err": err})
+		}
+	}
+}
+
+// trafficSince sums up the traffic of each side between the last stats and
+// the current ones. Counters are reset when the provider restarts.
+func trafficSince(last, stats []provider.SideStats) (origin, canary sideTraffic) {
+	type key struct {
+		service string
+		port    int32
+		side    string
+	}
+	lastStats := make(map[key]provider.SideStats, len(last))
+	for _, s := range last {
+		lastStats[key{s.Service, s.Port, s.Side}] = s
+	}
+
+	for _, s := range stats {
+		requests := s.Requests + s.Connections
+		errors := s.Errors + s.Failures
+		latencySum, latencyCount := s.Latency.Sum, s.Latency.Count
+		if l, ok := lastStats[key{s.Service, s.Port, s.Side}]; ok && l.Requests+l.Connections <= requests {
+			requests -= l.Requests + l.Connections
+			errors -= l.Errors + l.Failures
+			latencySum -= l.Latency.Sum
+			latencyCount -= l.Latency.Count
+		}
+
+		t := &origin
+		if s.Side == provider.SideCanary {
+			t = &canary
+		}
+		t.requests += requests
+		t.errors += errors
+		t.latencySum += latencySum
+		t.latencyCount += latencyCount
+	}
+	return origin, canary
+}
+
+// compare checks the traffic of canary against origin by the margins.
+// The phase is Error if there are too few requests to canary to compare.
+func compare(spec *releaseapi.CanaryComparison, origin, canary sideTraffic) releaseapi.CanaryComparisonStatus {
+	status := releaseapi.CanaryComparisonStatus{
+		Origin: origin.status(),
+		Canary: canary.status(),
+	}
+
+	minRequests := uint64(defaultMinRequests)
+	if spec.MinRequests > 0 {
+		minRequests = uint64(spec.MinRequests)
+	}
+	if canary.requests < minRequests {
+		status.Phase = releaseapi.CanaryMetricError
+		status.Message = fmt.Sprintf("%d requests to canary, want at least %d to compare", canary.requests, minRequests)
+		return status
+	}
+
+	if max := spec.MaxErrorRateIncrease; max != nil && canary.errorRate()-origin.errorRate() > *max {
+		status.Phase = releaseapi.CanaryMetricFailed
+		status.Message = fmt.Sprintf("error rate of canary %.2f%% is higher than origin %.2f%% by more than %v percentage points",
+			canary.errorRate(), origin.errorRate(), *max)
+		return status
+	}
+	if max := spec.MaxLatencyIncrease; max != nil && origin.latencyCount > 0 && canary.latencyCount > 0 &&
+		canary.latency() > origin.latency()*(1+*max/100) {
+		status.Phase = releaseapi.CanaryMetricFailed
+		status.Message = fmt.Sprintf("latency of canary %v is higher than origin %v by more than %v%%",
+			status.Canary.Latency, status.Origin.Latency, *max)
+		return status
+	}
+
+	status.Phase = releaseapi.CanaryMetricPassed
+	return status
+}
@@ -0,0 +1,60 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/caicloud/canary-release/proxies/provider"
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+)
+
+func TestTrafficSince(t *testing.T) {
+	last := []provider.SideStats{
+		{Service: "web", Port: 80, Side: provider.SideOrigin, Requests: 100, Errors: 1, Latency: provider.Histogram{Sum: 10, Count: 100}},
+		{Service: "web", Port: 80, Side: provider.SideCanary, Requests: 50, Errors: 5, Latency: provider.Histogram{Sum: 10, Count: 50}},
+	}
+	stats := []provider.SideStats{
+		{Service: "web", Port: 80, Side: provider.SideOrigin, Requests: 200, Errors: 2, Latency: provider.Histogram{Sum: 20, Count: 200}},
+		// the provider restarted
+		{Service: "web", Port: 80, Side: provider.SideCanary, Requests: 10, Errors: 1, Failures: 1, Latency: provider.Histogram{Sum: 1, Count: 10}},
+		{Service: "db", Port: 3306, Side: provider.SideCanary, Connections: 5, Latency: provider.Histogram{Sum: 1, Count: 5}},
+	}
+
+	origin, canary := trafficSince(last, stats)
+	if want := (sideTraffic{requests: 100, errors: 1, latencySum: 10, latencyCount: 100}); origin != want {
+		t.Errorf("trafficSince() origin = %+v, want %+v", origin, want)
+	}
+	if want := (sideTraffic{requests: 15, errors: 2, latencySum: 2, latencyCount: 15}); canary != want {
+		t.Errorf("trafficSince() canary = %+v, want %+v", canary, want)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	errorRate, latency := 1.0, 50.0
+	spec := &releaseapi.CanaryComparison{
+		MinRequests:          10,
+		MaxErrorRateIncrease: &errorRate,
+		MaxLatencyIncrease:   &latency,
+	}
+	origin := sideTraffic{requests: 1000, errors: 10, latencySum: 100, latencyCount: 1000}
+
+	tests := []struct {
+		name   string
+		canary sideTraffic
+		phase  releaseapi.CanaryMetricPhase
+	}{
+		{"too few requests", sideTraffic{requests: 9, errors: 9}, releaseapi.CanaryMetricError},
+		{"as good as origin", sideTraffic{requests: 100, errors: 1, latencySum: 10, latencyCount: 100}, releaseapi.CanaryMetricPassed},
+		{"errors within margin", sideTraffic{requests: 100, errors: 2, latencySum: 10, latencyCount: 100}, releaseapi.CanaryMetricPassed},
+		{"too many errors", sideTraffic{requests: 100, errors: 3, latencySum: 10, latencyCount: 100}, releaseapi.CanaryMetricFailed},
+		{"latency within margin", sideTraffic{requests: 100, latencySum: 15, latencyCount: 100}, releaseapi.CanaryMetricPassed},
+		{"too slow", sideTraffic{requests: 100, latencySum: 16, latencyCount: 100}, releaseapi.CanaryMetricFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := compare(spec, origin, tt.canary)
+			if status.Phase != tt.phase {
+				t.Errorf("compare() = %v with %q, want %v", status.Phase, status.Message, tt.phase)
+			}
+		})
+	}
+}
@@ -6,7 +6,11 @@ import (
 	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
 )
 
+// addCondition appends the condition to status, conditions of followers are dropped
 func (p *Proxy) addCondition(cr *releaseapi.CanaryRelease, condition releaseapi.CanaryReleaseCondition) error {
+	if !p.isLeader() {
+		return nil
+	}
 	apply := func(cr *releaseapi.CanaryRelease) error {
 		cr.Status.Conditions = append(cr.Status.Conditions, condition)
 		return nil
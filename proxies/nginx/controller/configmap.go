@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"fmt"
+
+	log "github.com/zoumo/logdog"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	corelister "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newConfigMapInformer returns an informer of the named configmap
+func (p *Proxy) newConfigMapInformer(namespace, name string) (corelister.ConfigMapLister, cache.Controller) {
+	tweak := func(options *metav1.ListOptions) {
+		options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+	}
+	indexer, informer := cache.NewIndexerInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				tweak(&options)
+				return p.cfg.Client.CoreV1().ConfigMaps(namespace).List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				tweak(&options)
+				return p.cfg.Client.CoreV1().ConfigMaps(namespace).Watch(options)
+			},
+		},
+		&core.ConfigMap{},
+		0,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    p.addConfigMap,
+			UpdateFunc: p.updateConfigMap,
+			DeleteFunc: p.deleteConfigMap,
+		},
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+	)
+	return corelister.NewConfigMapLister(indexer), informer
+}
+
+// configMapOf returns the namespace and name of the configmap tuning the
+// proxy, name is empty if there is no configmap
+func (p *Proxy) configMapOf() (string, string) {
+	if p.cfg.ConfigMap == "" {
+		return "", ""
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(p.cfg.ConfigMap)
+	if err != nil {
+		log.Warn("Invalid configmap of proxy, ignore it", log.Fields{"cm": p.cfg.ConfigMap, "err": err})
+		return "", ""
+	}
+	if namespace == "" {
+		namespace = p.namespace
+	}
+	return namespace, name
+}
+
+// getConfigMapData returns the data of the configmap tuning the proxy,
+// a missing configmap is ignored and the proxy uses the defaults
+func (p *Proxy) getConfigMapData() map[string]string {
+	namespace, name := p.configMapOf()
+	if name == "" || p.cmLister == nil {
+		return nil
+	}
+	cm, err := p.cmLister.ConfigMaps(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		log.Warn("ConfigMap of proxy is not found, use the defaults", log.Fields{"cm.name": name, "cm.ns": namespace})
+		return nil
+	}
+	if err != nil {
+		log.Warn("Error get configmap of proxy, use the defaults", log.Fields{"cm.name": name, "cm.ns": namespace, "err": err})
+		return nil
+	}
+	return cm.Data
+}
+
+func (p *Proxy) addConfigMap(obj interface{}) {
+	cm := obj.(*core.ConfigMap)
+	p.enqueueForConfigMap(cm)
+}
+
+func (p *Proxy) updateConfigMap(oldObj, curObj interface{}) {
+	old := oldObj.(*core.ConfigMap)
+	cur := curObj.(*core.ConfigMap)
+
+	if old.ResourceVersion == cur.ResourceVersion {
+		// Periodic resync will send update events for all known Objects.
+		// Two different versions of the same Objects will always have different RVs.
+		return
+	}
+	p.enqueueForConfigMap(cur)
+}
+
+func (p *Proxy) deleteConfigMap(obj interface{}) {
+	cm, ok := obj.(*core.ConfigMap)
+
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("Couldn't get object from tombstone %#v", obj))
+			return
+		}
+		cm, ok = tombstone.Obj.(*core.ConfigMap)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("Tombstone contained object that is not a ConfigMap %#v", obj))
+			return
+		}
+	}
+	p.enqueueForConfigMap(cm)
+}
+
+// enqueueForConfigMap resyncs the canary release if the configmap tunes the proxy
+func (p *Proxy) enqueueForConfigMap(cm *core.ConfigMap) {
+	cr, err := p.crLister.CanaryReleases(p.namespace).Get(p.canaryrelease)
+	if err != nil {
+		return
+	}
+	if p.canaryFiltered(cr) {
+		return
+	}
+	namespace, name := p.configMapOf()
+	if cm.Namespace != namespace || cm.Name != name {
+		return
+	}
+
+	log.Debug("ConfigMap of proxy changed", log.Fields{"cm.name": cm.Name, "cm.ns": cm.Namespace})
+	p.queue.Enqueue(cr)
+}
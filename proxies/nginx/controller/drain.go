@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/caicloud/canary-release/pkg/api"
+	"github.com/caicloud/canary-release/proxies/provider"
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+	log "github.com/zoumo/logdog"
+)
+
+const (
+	// defaultDrainTimeout is the drain timeout if it's not set in canary release
+	defaultDrainTimeout = 30 * time.Second
+	// drainCheckInterval is the interval of checking active connections to canary
+	drainCheckInterval = 1 * time.Second
+)
+
+// errDraining means the cleanup waits for connections to canary to finish,
+// the canary release has been requeued to continue it
+var errDraining = fmt.Errorf("canary is draining")
+
+// drainCanary sends all new traffic to origin, and returns true once no
+// connections to canary are active or the drain timeout passes. The worker
+// is not blocked while draining, the canary release is requeued to check
+// the connections again.
+func (p *Proxy) drainCanary(cr *releaseapi.CanaryRelease) bool {
+	timeout := drainTimeout(cr)
+	if timeout <= 0 || p.runningSplit == nil {
+		return true
+	}
+
+	if p.drainStartTime.IsZero() {
+		log.Info("Draining canary", log.Fields{"cr": cr.Name, "timeout": timeout})
+		_ = p.addCondition(cr, api.NewCondition(api.ReasonDraining, ""))
+		split := drainedSplit(p.runningSplit)
+		if err := p.provider.Apply(split); err != nil {
+			log.Error("Error apply drained traffic split, skip draining", log.Fields{"err": err})
+			return true
+		}
+		p.runningSplit = &split
+		p.drainStartTime = time.Now()
+	}
+
+	if p.canaryDrained() {
+		log.Info("Canary drained", log.Fields{"cr": cr.Name})
+		return true
+	}
+	if time.Since(p.drainStartTime) >= timeout {
+		log.Warn("Drain timeout, active connections to canary will be closed", log.Fields{"cr": cr.Name, "timeout": timeout})
+		return true
+	}
+	p.queue.EnqueueAfter(cr, drainCheckInterval)
+	return false
+}
+
+// canaryDrained returns true if no connections to canary are active,
+// it's always false without stats so draining waits for the whole timeout
+func (p *Proxy) canaryDrained() bool {
+	collector, ok := p.provider.(provider.StatsCollector)
+	if !ok {
+		return false
+	}
+	stats, err := collector.Stats()
+	if err != nil {
+		log.Warn("Error get traffic stats", log.Fields{"err": err})
+		return false
+	}
+	for _, s := range stats {
+		if s.Side == provider.SideCanary && s.Active > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// drainTimeout returns the drain timeout of the canary release
+func drainTimeout(cr *releaseapi.CanaryRelease) time.Duration {
+	if cr.Spec.DrainTimeoutSeconds == nil {
+		return defaultDrainTimeout
+	}
+	return time.Duration(*cr.Spec.DrainTimeoutSeconds) * time.Second
+}
+
+// drainedSplit returns a copy of the split sending all traffic to origin.
+// Rules, cookies, server names and mirror are kept but marked drained, so
+// their upstreams use origin endpoints and nginx is not reloaded.
+func drainedSplit(split *provider.Split) provider.Split {
+	drained := provider.Split{Options: split.Options}
+	for _, s := range split.HTTP {
+		s.Endpoints = drainedEndpoints(s.Endpoints)
+		if s.Stickiness != nil {
+			stickiness := *s.Stickiness
+			stickiness.CanaryWeight = 0
+			s.Stickiness = &stickiness
+		}
+		s.Drained = true
+		drained.HTTP = append(drained.HTTP, s)
+	}
+	for _, s := range split.TCP {
+		s.Endpoints = drainedEndpoints(s.Endpoints)
+		s.Drained = true
+		drained.TCP = append(drained.TCP, s)
+	}
+	for _, s := range split.UDP {
+		s.Endpoints = drainedEndpoints(s.Endpoints)
+		s.Drained = true
+		drained.UDP = append(drained.UDP, s)
+	}
+	return drained
+}
+
+// drainedEndpoints returns a copy of the endpoints, origin endpoints
+// share all the weight
+func drainedEndpoints(endpoints []api.Endpoint) []api.Endpoint {
+	ret := make([]api.Endpoint, 0, len(endpoints))
+	for _, ep := range endpoints {
+		ep.Weight = 1
+		if ep.Canary {
+			ep.Weight = 0
+		}
+		ret = append(ret, ep)
+	}
+	return ret
+}
+
+// originOnlyHTTP removes routing to canary by rules, cookies and mirror
+func originOnlyHTTP(s api.HTTPService) api.HTTPService {
+	s.Rules = nil
+	s.Stickiness = nil
+	s.MirrorPercent = 0
+	return s
+}
+
+// originOnlyL4 removes routing to canary by server names
+func originOnlyL4(s api.L4Service) api.L4Service {
+	s.ServerNames = nil
+	return s
+}
@@ -0,0 +1,43 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/caicloud/canary-release/pkg/api"
+	"github.com/caicloud/canary-release/proxies/provider"
+)
+
+func TestDrainedSplit(t *testing.T) {
+	split := &provider.Split{
+		HTTP: []api.HTTPService{
+			{
+				Port: 8080,
+				Endpoints: []api.Endpoint{
+					{Address: "10.0.0.1", Port: 80, Weight: 0},
+					{Address: "10.0.1.1", Port: 80, Weight: 1, Canary: true},
+				},
+				Rules:      []api.HTTPRule{{Header: "X-Canary"}},
+				Stickiness: &api.HTTPStickiness{Cookie: "canary", CanaryWeight: 100},
+			},
+		},
+		TCP: []api.L4Service{
+			{Port: 8081, ServerNames: []string{"canary.example.com"}},
+		},
+	}
+
+	drained := drainedSplit(split)
+	s := drained.HTTP[0]
+	if s.Endpoints[0].Weight != 1 || s.Endpoints[1].Weight != 0 || s.Stickiness.CanaryWeight != 0 || !s.Drained {
+		t.Errorf("drainedSplit() = %+v, want all traffic to origin", s)
+	}
+	if !drained.TCP[0].Drained {
+		t.Errorf("drainedSplit() = %+v, want server names drained", drained.TCP[0])
+	}
+	// routing is kept so the drained split is applied without reloading
+	if s.Rules == nil || s.Stickiness.Cookie != "canary" || drained.TCP[0].ServerNames == nil {
+		t.Errorf("drainedSplit() changes routing of %+v and %+v", s, drained.TCP[0])
+	}
+	if split.HTTP[0].Endpoints[1].Weight != 1 || split.HTTP[0].Stickiness.CanaryWeight != 100 || split.HTTP[0].Drained {
+		t.Errorf("drainedSplit() changes the original split")
+	}
+}
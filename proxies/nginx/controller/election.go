@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"os"
+
+	"github.com/caicloud/canary-release/pkg/election"
+	log "github.com/zoumo/logdog"
+)
+
+// leaseSuffix is the suffix of the lease proxies of a canary release elect a leader by
+const leaseSuffix = "-proxy"
+
+// newElector returns the elector among replicas of the proxy, or nil if
+// leader election is disabled
+func (p *Proxy) newElector() *election.Elector {
+	if !p.cfg.LeaderElect {
+		return nil
+	}
+	identity := p.cfg.PodName
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Fatal("Error get hostname as identity of leader election", log.Fields{"err": err})
+		}
+		identity = hostname
+	}
+	return election.NewElector(election.Config{
+		Client:    p.cfg.Client.CoordinationV1(),
+		Namespace: p.namespace,
+		Name:      p.canaryrelease + leaseSuffix,
+		Identity:  identity,
+		OnChange:  p.leadershipChanged,
+	})
+}
+
+// isLeader returns true if the proxy is allowed to change resources in cluster.
+// All replicas apply traffic split to their own data plane, but only the
+// leader creates and updates services, manifests and status.
+func (p *Proxy) isLeader() bool {
+	return p.elector == nil || p.elector.IsLeader()
+}
+
+// leadershipChanged resyncs the canary release, a new leader takes over the
+// changes to resources in cluster
+func (p *Proxy) leadershipChanged(leader bool) {
+	cr, err := p.crLister.CanaryReleases(p.namespace).Get(p.canaryrelease)
+	if err != nil || p.canaryFiltered(cr) {
+		return
+	}
+	p.queue.Enqueue(cr)
+}
@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/caicloud/canary-release/pkg/api"
+	log "github.com/zoumo/logdog"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+// endpointsFiltered checks whether needs to filter the endpoints.
+// Only endpoints of forked and canary services are concerned.
+func (p *Proxy) endpointsFiltered(ep *core.Endpoints) bool {
+	if p.namespace != ep.Namespace {
+		return true
+	}
+	cr, err := p.crLister.CanaryReleases(p.namespace).Get(p.canaryrelease)
+	if err != nil {
+		return true
+	}
+	for _, svc := range cr.Spec.Service {
+		if ep.Name == svc.Service+forkedServiceSuffix || ep.Name == svc.Service+canaryServiceSuffix {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Proxy) addEndpoints(obj interface{}) {
+	ep := obj.(*core.Endpoints)
+	if p.endpointsFiltered(ep) {
+		return
+	}
+	p.enqueueForEndpoints(ep)
+}
+
+func (p *Proxy) updateEndpoints(oldObj, curObj interface{}) {
+	old := oldObj.(*core.Endpoints)
+	cur := curObj.(*core.Endpoints)
+
+	if old.ResourceVersion == cur.ResourceVersion {
+		// Periodic resync will send update events for all known Objects.
+		// Two different versions of the same Objects will always have different RVs.
+		return
+	}
+
+	if p.endpointsFiltered(cur) {
+		return
+	}
+	p.enqueueForEndpoints(cur)
+}
+
+func (p *Proxy) deleteEndpoints(obj interface{}) {
+	ep, ok := obj.(*core.Endpoints)
+
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("Couldn't get object from tombstone %#v", obj))
+			return
+		}
+		ep, ok = tombstone.Obj.(*core.Endpoints)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("Tombstone contained object that is not a Endpoints %#v", obj))
+			return
+		}
+	}
+
+	if p.endpointsFiltered(ep) {
+		return
+	}
+	p.enqueueForEndpoints(ep)
+}
+
+func (p *Proxy) enqueueForEndpoints(ep *core.Endpoints) {
+	cr, err := p.crLister.CanaryReleases(p.namespace).Get(p.canaryrelease)
+	if err != nil {
+		return
+	}
+	if p.canaryFiltered(cr) {
+		return
+	}
+
+	log.Debug("Endpoints changed", log.Fields{"ep.name": ep.Name, "ep.ns": ep.Namespace})
+	// pods usually come and go in batch, delay to merge them
+	p.queue.EnqueueAfter(cr, 1*time.Second)
+}
+
+// getEndpoints returns the pod endpoints of forked and canary services for
+// the given port. The weight of each side is spread over its pods, so the
+// ratio of the sum of weights is still originWeight:canaryWeight.
+// If a side has no ready pods, the cluster IP of its service is used as the
+// endpoint of the side. If the cluster IP is not allocated either, the side
+// is dropped and the other side takes all the weight.
+func (p *Proxy) getEndpoints(col *serviceCollection, protocol core.Protocol, port, originWeight, canaryWeight int32) []api.Endpoint {
+	origin := p.getSideEndpoints(col.forked, protocol, port)
+	canary := p.getSideEndpoints(col.canary, protocol, port)
+
+	// origin pod weight / canary pod weight = (originWeight * nc) / (canaryWeight * no)
+	originPodWeight := originWeight * int32(len(canary))
+	canaryPodWeight := canaryWeight * int32(len(origin))
+	if len(canary) == 0 {
+		originPodWeight = 1
+	}
+	if len(origin) == 0 {
+		canaryPodWeight = 1
+	}
+	if d := gcd(originPodWeight, canaryPodWeight); d > 1 {
+		originPodWeight /= d
+		canaryPodWeight /= d
+	}
+
+	endpoints := make([]api.Endpoint, 0, len(origin)+len(canary))
+	for _, ep := range origin {
+		ep.Weight = originPodWeight
+		endpoints = append(endpoints, ep)
+	}
+	for _, ep := range canary {
+		ep.Weight = canaryPodWeight
+		ep.Canary = true
+		endpoints = append(endpoints, ep)
+	}
+	return endpoints
+}
+
+// getServiceEndpoints returns the ready pod endpoints of the service port
+func (p *Proxy) getServiceEndpoints(svc *core.Service, protocol core.Protocol, port int32) []api.Endpoint {
+	var svcPort *core.ServicePort
+	for i := range svc.Spec.Ports {
+		sp := &svc.Spec.Ports[i]
+		spProtocol := sp.Protocol
+		if spProtocol == "" {
+			spProtocol = core.ProtocolTCP
+		}
+		if sp.Port == port && spProtocol == protocol {
+			svcPort = sp
+			break
+		}
+	}
+	if svcPort == nil {
+		return nil
+	}
+
+	ep, err := p.epLister.Endpoints(p.namespace).Get(svc.Name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		log.Warn("Error get endpoints", log.Fields{"svc.name": svc.Name, "err": err})
+		return nil
+	}
+
+	var endpoints []api.Endpoint
+	for _, subset := range ep.Subsets {
+		for _, epPort := range subset.Ports {
+			epProtocol := epPort.Protocol
+			if epProtocol == "" {
+				epProtocol = core.ProtocolTCP
+			}
+			if epPort.Name != svcPort.Name || epProtocol != protocol {
+				continue
+			}
+			for _, addr := range subset.Addresses {
+				endpoints = append(endpoints, api.Endpoint{
+					Address: addr.IP,
+					Port:    epPort.Port,
+				})
+			}
+		}
+	}
+	sort.Sort(sortByAddress(endpoints))
+	return endpoints
+}
+
+// getSideEndpoints returns the ready pod endpoints of the service port, or
+// the cluster IP of the service if no pods are ready. Services rendered from
+// charts have no cluster IP, so it's looked up in cluster. The lua balancer
+// only accepts IP addresses, it returns nil if there is no IP.
+func (p *Proxy) getSideEndpoints(svc *core.Service, protocol core.Protocol, port int32) []api.Endpoint {
+	if endpoints := p.getServiceEndpoints(svc, protocol, port); len(endpoints) > 0 {
+		return endpoints
+	}
+	inCluster, err := p.svcLister.Services(p.namespace).Get(svc.Name)
+	if err != nil {
+		log.Warn("Error get service of side without ready pods", log.Fields{"svc.name": svc.Name, "err": err})
+		return nil
+	}
+	if ip := inCluster.Spec.ClusterIP; ip != "" && ip != core.ClusterIPNone {
+		return []api.Endpoint{{Address: ip, Port: port}}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/caicloud/canary-release/pkg/api"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelister "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestService(name string) *core.Service {
+	return &core.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test"},
+		Spec: core.ServiceSpec{
+			Ports: []core.ServicePort{
+				{Name: "http", Port: 80, Protocol: core.ProtocolTCP},
+			},
+		},
+	}
+}
+
+func newTestEndpoints(name string, ips ...string) *core.Endpoints {
+	addresses := make([]core.EndpointAddress, 0, len(ips))
+	for _, ip := range ips {
+		addresses = append(addresses, core.EndpointAddress{IP: ip})
+	}
+	return &core.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test"},
+		Subsets: []core.EndpointSubset{
+			{
+				Addresses: addresses,
+				Ports: []core.EndpointPort{
+					{Name: "http", Port: 8080, Protocol: core.ProtocolTCP},
+				},
+			},
+		},
+	}
+}
+
+func TestGetEndpoints(t *testing.T) {
+	col := &serviceCollection{
+		forked: newTestService("svc-forked"),
+		canary: newTestService("svc-canary"),
+	}
+	withClusterIP := func(svc *core.Service, ip string) *core.Service {
+		svc.Spec.ClusterIP = ip
+		return svc
+	}
+
+	tests := []struct {
+		name      string
+		services  []*core.Service
+		endpoints []*core.Endpoints
+		want      []api.Endpoint
+	}{
+		{
+			"no ready pods",
+			[]*core.Service{
+				withClusterIP(newTestService("svc-forked"), "10.96.0.1"),
+				withClusterIP(newTestService("svc-canary"), "10.96.0.2"),
+			},
+			nil,
+			[]api.Endpoint{
+				{Address: "10.96.0.1", Port: 80, Weight: 9},
+				{Address: "10.96.0.2", Port: 80, Weight: 1, Canary: true},
+			},
+		},
+		{
+			"spread weight over pods",
+			nil,
+			[]*core.Endpoints{
+				newTestEndpoints("svc-forked", "10.0.0.2", "10.0.0.1", "10.0.0.3"),
+				newTestEndpoints("svc-canary", "10.0.1.1"),
+			},
+			[]api.Endpoint{
+				{Address: "10.0.0.1", Port: 8080, Weight: 3},
+				{Address: "10.0.0.2", Port: 8080, Weight: 3},
+				{Address: "10.0.0.3", Port: 8080, Weight: 3},
+				{Address: "10.0.1.1", Port: 8080, Weight: 1, Canary: true},
+			},
+		},
+		{
+			"canary has no ready pods",
+			[]*core.Service{withClusterIP(newTestService("svc-canary"), "10.96.0.2")},
+			[]*core.Endpoints{
+				newTestEndpoints("svc-forked", "10.0.0.1", "10.0.0.2"),
+			},
+			[]api.Endpoint{
+				{Address: "10.0.0.1", Port: 8080, Weight: 9},
+				{Address: "10.0.0.2", Port: 8080, Weight: 9},
+				{Address: "10.96.0.2", Port: 80, Weight: 2, Canary: true},
+			},
+		},
+		{
+			"canary has no ready pods nor cluster IP",
+			[]*core.Service{newTestService("svc-canary")},
+			[]*core.Endpoints{
+				newTestEndpoints("svc-forked", "10.0.0.1", "10.0.0.2"),
+			},
+			[]api.Endpoint{
+				{Address: "10.0.0.1", Port: 8080, Weight: 1},
+				{Address: "10.0.0.2", Port: 8080, Weight: 1},
+			},
+		},
+		{
+			"origin is not in cluster",
+			nil,
+			[]*core.Endpoints{
+				newTestEndpoints("svc-canary", "10.0.1.1"),
+			},
+			[]api.Endpoint{
+				{Address: "10.0.1.1", Port: 8080, Weight: 1, Canary: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			epIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			for _, ep := range tt.endpoints {
+				_ = epIndexer.Add(ep)
+			}
+			svcIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			for _, svc := range tt.services {
+				_ = svcIndexer.Add(svc)
+			}
+			p := &Proxy{
+				namespace: "test",
+				epLister:  corelister.NewEndpointsLister(epIndexer),
+				svcLister: corelister.NewServiceLister(svcIndexer),
+			}
+			got := p.getEndpoints(col, core.ProtocolTCP, 80, 90, 10)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getEndpoints() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
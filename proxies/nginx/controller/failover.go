@@ -0,0 +1,60 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/caicloud/canary-release/pkg/api"
+	"github.com/caicloud/canary-release/proxies/provider"
+	log "github.com/zoumo/logdog"
+)
+
+// failoverCheckPeriod is the period of checking failed tries to canary
+const failoverCheckPeriod = 10 * time.Second
+
+// checkFailover records a condition when tries to canary start failing and
+// fail over to origin, and another one when canary recovers. The provider
+// skips failing endpoints by itself, this only reports it on the CanaryRelease.
+func (p *Proxy) checkFailover() {
+	collector, ok := p.provider.(provider.StatsCollector)
+	if !ok {
+		return
+	}
+	stats, err := collector.Stats()
+	if err != nil {
+		log.Debug("Error get traffic stats", log.Fields{"err": err})
+		return
+	}
+
+	var failures uint64
+	for _, s := range stats {
+		if s.Side == provider.SideCanary {
+			failures += s.Failures
+		}
+	}
+	newFailures := failures - p.canaryFailures
+	if failures < p.canaryFailures {
+		// counters are reset when the provider restarts
+		newFailures = failures
+	}
+	failed := newFailures > 0
+	p.canaryFailures = failures
+	if failed == p.canaryFailing {
+		return
+	}
+
+	cr, err := p.crLister.CanaryReleases(p.namespace).Get(p.canaryrelease)
+	if err != nil || p.canaryFiltered(cr) || cr.DeletionTimestamp != nil {
+		return
+	}
+
+	condition := api.NewCondition(api.ReasonCanaryRecovered, "")
+	if failed {
+		condition = api.NewCondition(api.ReasonCanaryFailover, fmt.Sprintf("%d tries to canary endpoints failed in the last %v, failing endpoints are skipped and traffic goes to origin", newFailures, failoverCheckPeriod))
+	}
+	if err := p.addCondition(cr, condition); err != nil {
+		log.Error("Error add failover condition", log.Fields{"cr": p.canaryrelease, "err": err})
+		return
+	}
+	p.canaryFailing = failed
+}
@@ -0,0 +1,44 @@
+package controller
+
+import (
+	"github.com/caicloud/canary-release/pkg/api"
+	"github.com/caicloud/canary-release/pkg/gate"
+	"github.com/caicloud/canary-release/pkg/util"
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+	log "github.com/zoumo/logdog"
+)
+
+// adoptionGated returns true if the adoption waits for gates. The leader calls
+// the gates before the Adopted path of cleanup, a rejection pauses the canary
+// release with a condition and gates are called again after it's resumed.
+// Followers keep the current split until the leader finishes the adoption.
+func (p *Proxy) adoptionGated(cr *releaseapi.CanaryRelease) (bool, error) {
+	if cr.Spec.Transition != releaseapi.CanaryTrasitionAdopted || cr.Status.Phase != releaseapi.CanaryTrasitionNone {
+		return false, nil
+	}
+	if !gate.Has(cr.Spec.Gates, releaseapi.CanaryGateAdopt) {
+		return false, nil
+	}
+	if !p.isLeader() || cr.Spec.Paused {
+		return true, nil
+	}
+	err := gate.Check(cr.Spec.Gates, gate.NewPayload(cr, releaseapi.CanaryGateAdopt))
+	if err == nil {
+		return false, nil
+	}
+
+	log.Warn("Adoption is rejected by gate, pause it", log.Fields{"cr.name": cr.Name, "cr.ns": cr.Namespace, "err": err})
+	message := "adoption: " + err.Error()
+	_, err = util.UpdateCRWithRetries(
+		p.cfg.Client.ReleaseV1alpha1().CanaryReleases(cr.Namespace),
+		p.crLister,
+		cr.Namespace,
+		cr.Name,
+		func(cr *releaseapi.CanaryRelease) error {
+			cr.Spec.Paused = true
+			cr.Status.Conditions = append(cr.Status.Conditions, api.NewCondition(api.ReasonGateRejected, message))
+			return nil
+		},
+	)
+	return true, err
+}
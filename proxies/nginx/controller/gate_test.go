@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caicloud/canary-release/pkg/election"
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+)
+
+func TestAdoptionGated(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer server.Close()
+
+	newCR := func(transition releaseapi.CanaryTrasition, gateType releaseapi.CanaryGateType, paused bool) *releaseapi.CanaryRelease {
+		cr := &releaseapi.CanaryRelease{}
+		cr.Name = "web"
+		cr.Spec.Transition = transition
+		cr.Spec.Paused = paused
+		cr.Spec.Gates = []releaseapi.CanaryGate{{Name: "qa", Type: gateType, URL: server.URL}}
+		return cr
+	}
+	// a follower is not the leader until it acquires the lease
+	follower := election.NewElector(election.Config{})
+
+	tests := []struct {
+		name    string
+		cr      *releaseapi.CanaryRelease
+		elector *election.Elector
+		want    bool
+		calls   int
+	}{
+		{"not adopted", newCR(releaseapi.CanaryTrasitionNone, releaseapi.CanaryGateAdopt, false), nil, false, 0},
+		{"no adoption gate", newCR(releaseapi.CanaryTrasitionAdopted, releaseapi.CanaryGateStep, false), nil, false, 0},
+		{"accepted", newCR(releaseapi.CanaryTrasitionAdopted, releaseapi.CanaryGateAdopt, false), nil, false, 1},
+		{"paused after rejection", newCR(releaseapi.CanaryTrasitionAdopted, releaseapi.CanaryGateAdopt, true), nil, true, 0},
+		{"follower", newCR(releaseapi.CanaryTrasitionAdopted, releaseapi.CanaryGateAdopt, false), follower, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = 0
+			p := &Proxy{elector: tt.elector}
+			got, err := p.adoptionGated(tt.cr)
+			if err != nil {
+				t.Fatalf("adoptionGated() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("adoptionGated() = %v, want %v", got, tt.want)
+			}
+			if calls != tt.calls {
+				t.Errorf("gate called %d times, want %d", calls, tt.calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/caicloud/canary-release/pkg/util"
+	"github.com/caicloud/canary-release/proxies/health"
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+	log "github.com/zoumo/logdog"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// healthTargets returns the forked and canary services of ports with health checks
+func (p *Proxy) healthTargets(svcCol []*serviceCollection) []health.Target {
+	var targets []health.Target
+	for _, col := range svcCol {
+		for _, port := range col.service.Ports {
+			if port.HealthCheck == nil {
+				continue
+			}
+			check := *port.HealthCheck
+			if check.Type == "" && port.Protocol == releaseapi.ProtocolUDP {
+				check.Type = releaseapi.CanaryHealthCheckUDP
+			}
+			sides := []struct {
+				svc    *core.Service
+				canary bool
+			}{
+				{col.forked, false},
+				{col.canary, true},
+			}
+			for _, side := range sides {
+				host := fmt.Sprintf("%s.%s", side.svc.Name, p.namespace)
+				targets = append(targets, health.Target{
+					Service: col.name,
+					Port:    port.Port,
+					Canary:  side.canary,
+					Address: net.JoinHostPort(host, strconv.Itoa(int(port.Port))),
+					Check:   check,
+				})
+			}
+		}
+	}
+	return targets
+}
+
+// healthyWeights moves the weight of an unhealthy side to the other side,
+// the weights are kept if both sides are unhealthy
+func (p *Proxy) healthyWeights(service string, port int32, canaryWeight, originWeight int32) (int32, int32) {
+	canaryHealthy := p.prober.Healthy(service, port, true)
+	originHealthy := p.prober.Healthy(service, port, false)
+	switch {
+	case canaryHealthy == originHealthy:
+		return canaryWeight, originWeight
+	case canaryHealthy:
+		return 100, 0
+	default:
+		return 0, 100
+	}
+}
+
+// canaryUnhealthy returns true if all traffic of the port goes to origin by
+// health, including the traffic routed to canary by rules, cookies and server names
+func (p *Proxy) canaryUnhealthy(service string, port int32) bool {
+	return !p.prober.Healthy(service, port, true) && p.prober.Healthy(service, port, false)
+}
+
+// healthChanged resyncs the canary release to apply the health of sides
+func (p *Proxy) healthChanged() {
+	cr, err := p.crLister.CanaryReleases(p.namespace).Get(p.canaryrelease)
+	if err != nil || p.canaryFiltered(cr) {
+		return
+	}
+	p.queue.Enqueue(cr)
+}
+
+// syncHealthStatus records the health check results in status if they are changed
+func (p *Proxy) syncHealthStatus(cr *releaseapi.CanaryRelease) error {
+	var statuses []releaseapi.CanaryHealthStatus
+	for _, r := range p.prober.Results() {
+		statuses = append(statuses, releaseapi.CanaryHealthStatus{
+			Service:            r.Service,
+			Port:               r.Port,
+			Canary:             r.Canary,
+			Healthy:            r.Healthy,
+			LastTransitionTime: metav1.NewTime(r.LastTransitionTime),
+			Message:            r.Message,
+		})
+	}
+	if healthStatusesEqual(cr.Status.Health, statuses) {
+		return nil
+	}
+
+	log.Debug("update canary release health status", log.Fields{"cr.name": cr.Name, "cr.ns": cr.Namespace})
+	_, err := util.UpdateCRWithRetries(p.cfg.Client.ReleaseV1alpha1().CanaryReleases(p.namespace), p.crLister, cr.Namespace, cr.Name,
+		func(cr *releaseapi.CanaryRelease) error {
+			cr.Status.Health = statuses
+			return nil
+		},
+	)
+	return err
+}
+
+// healthStatusesEqual compares the statuses except the times, which lose
+// precision in the stored status. Messages are ignored too, they change
+// with every failed probe.
+func healthStatusesEqual(a, b []releaseapi.CanaryHealthStatus) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Service != b[i].Service ||
+			a[i].Port != b[i].Port ||
+			a[i].Canary != b[i].Canary ||
+			a[i].Healthy != b[i].Healthy {
+			return false
+		}
+	}
+	return true
+}
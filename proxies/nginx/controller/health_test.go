@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/caicloud/canary-release/proxies/health"
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+	core "k8s.io/api/core/v1"
+	corelister "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestGetUpstreamServiceUnhealthyCanary(t *testing.T) {
+	origin, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer origin.Close()
+
+	weight := int32(20)
+	col := &serviceCollection{
+		name:   "svc",
+		forked: newTestService("svc-forked"),
+		canary: newTestService("svc-canary"),
+		service: releaseapi.CanaryService{
+			Service: "svc",
+			Ports: []releaseapi.CanaryPort{
+				{
+					Port:     80,
+					Protocol: releaseapi.ProtocolHTTP,
+					Config: releaseapi.CanaryConfig{
+						Weight:     &weight,
+						Rules:      []releaseapi.CanaryRule{{Header: "X-Canary", Value: "always"}},
+						Stickiness: &releaseapi.CanaryStickiness{},
+					},
+				},
+			},
+		},
+	}
+	svcIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for i, svc := range []*core.Service{newTestService("svc-forked"), newTestService("svc-canary")} {
+		svc.Spec.ClusterIP = fmt.Sprintf("10.96.0.%d", i+1)
+		_ = svcIndexer.Add(svc)
+	}
+	p := &Proxy{
+		namespace: "test",
+		epLister:  corelister.NewEndpointsLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+		svcLister: corelister.NewServiceLister(svcIndexer),
+		prober:    health.NewProber(func() {}),
+	}
+	defer p.prober.Stop()
+
+	http, _, _ := p.getUpsteamService([]*serviceCollection{col})
+	if len(http[0].Rules) != 1 || http[0].Stickiness == nil {
+		t.Fatalf("getUpsteamService() of healthy canary = %+v, want rules and stickiness", http[0])
+	}
+
+	check := releaseapi.CanaryHealthCheck{FailureThreshold: 1, IntervalSeconds: 1}
+	p.prober.Update([]health.Target{
+		{Service: "svc", Port: 80, Address: origin.Addr().String(), Check: check},
+		{Service: "svc", Port: 80, Canary: true, Address: "127.0.0.1:1", Check: check},
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for !p.canaryUnhealthy("svc", 80) {
+		if time.Now().After(deadline) {
+			t.Fatal("canary is not probed unhealthy")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	http, _, _ = p.getUpsteamService([]*serviceCollection{col})
+	s := http[0]
+	if s.Rules != nil || s.Stickiness != nil {
+		t.Errorf("getUpsteamService() of unhealthy canary = %+v, want no rules and stickiness", s)
+	}
+	for _, ep := range s.Endpoints {
+		if ep.Canary && ep.Weight != 0 {
+			t.Errorf("getUpsteamService() of unhealthy canary has endpoint %+v, want no weight", ep)
+		}
+	}
+}
@@ -1,18 +1,25 @@
 package controller
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
+	"net/http"
 	"os"
 	"os/exec"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/caicloud/canary-release/pkg/api"
 	"github.com/caicloud/canary-release/proxies/nginx/config"
 	"github.com/caicloud/canary-release/proxies/nginx/template"
+	"github.com/caicloud/canary-release/proxies/provider"
 	"github.com/mitchellh/go-ps"
 	log "github.com/zoumo/logdog"
 
@@ -23,6 +30,22 @@ var (
 	tmplPath = "/etc/nginx/template/nginx.tmpl"
 	cfgPath  = "/etc/nginx/nginx.conf"
 	binary   = "/usr/sbin/nginx"
+
+	// statusURL is the status page of nginx
+	statusURL = "http://127.0.0.1:7070/nginx_status"
+	// httpBackendsURL is the local control endpoint of http upstreams
+	httpBackendsURL = "http://127.0.0.1:7070/configuration/backends"
+	// streamBackendsAddr is the local control endpoint of stream upstreams
+	streamBackendsAddr = "127.0.0.1:7071"
+	// httpMetricsURL is the local endpoint of http traffic stats
+	httpMetricsURL = "http://127.0.0.1:7070/canary_metrics"
+	// streamMetricsAddr is the local endpoint of stream traffic stats
+	streamMetricsAddr = "127.0.0.1:7072"
+)
+
+var (
+	_ provider.TrafficProvider = &NginxController{}
+	_ provider.StatsCollector  = &NginxController{}
 )
 
 // NginxController ...
@@ -30,6 +53,11 @@ type NginxController struct {
 	binary   string
 	cmdArgs  []string
 	template *template.Template
+
+	// runningConfig is the config used by nginx
+	runningConfig *config.TemplateConfig
+	// mu protects runningConfig from the metrics handler
+	mu sync.RWMutex
 }
 
 // NewNginxController returns a new NginxController
@@ -168,9 +196,56 @@ Error: %v
 	return nil
 }
 
-// OnUpdate is called by proxy.sync periodically to keep the configuration in sync
-func (n *NginxController) OnUpdate(cfg config.TemplateConfig) error {
+// Apply is called by proxy.sync periodically to keep the configuration in sync
+func (n *NginxController) Apply(split provider.Split) error {
+	cfg, err := config.NewTemplateConfig(split.Options)
+	if err != nil {
+		return fmt.Errorf("Error read nginx configuration: %v", err)
+	}
+	cfg.HTTPBackends = split.HTTP
+	cfg.TCPBackends = split.TCP
+	cfg.UDPBackends = split.UDP
+
+	// only endpoints or weights are changed, apply them without reloading
+	if n.runningConfig != nil && n.runningConfig.EqualIgnoreEndpoints(&cfg) {
+		err := n.configureBackends(cfg)
+		if err == nil {
+			log.Info("Dynamic reconfiguration succeeded")
+			n.setRunningConfig(&cfg)
+			return nil
+		}
+		log.Warn("Dynamic reconfiguration failed, fall back to reload nginx", log.Fields{"err": err})
+	}
+
+	err = n.reload(cfg)
+	if err != nil {
+		return err
+	}
+
+	// wait for nginx workers with new config to serve the control endpoint
+	err = wait.PollImmediate(1*time.Second, 10*time.Second, func() (bool, error) {
+		if err := n.configureBackends(cfg); err != nil {
+			log.Warn("Error configure backends, retry", log.Fields{"err": err})
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("Error configure backends after reloading nginx: %v", err)
+	}
+
+	n.setRunningConfig(&cfg)
+	return nil
+}
+
+func (n *NginxController) setRunningConfig(cfg *config.TemplateConfig) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.runningConfig = cfg
+}
 
+// reload writes the nginx.conf and reloads nginx
+func (n *NginxController) reload(cfg config.TemplateConfig) error {
 	backlogSize := sysctlSomaxconn()
 
 	wp, err := strconv.Atoi(cfg.Cfg.WorkerProcesses)
@@ -208,9 +283,216 @@ func (n *NginxController) OnUpdate(cfg config.TemplateConfig) error {
 		return fmt.Errorf("%v\n%v", err, string(o))
 	}
 
+	log.Info("Nginx reloaded")
+	return nil
+}
+
+// configureBackends posts the endpoints of all upstreams to the running nginx,
+// the lua balancer picks them up without reloading
+func (n *NginxController) configureBackends(cfg config.TemplateConfig) error {
+	data, err := json.Marshal(cfg.HTTPDynamicBackends())
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Post(httpBackendsURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("unexpected status code %v when configuring http backends", resp.StatusCode)
+	}
+
+	data, err = json.Marshal(cfg.StreamDynamicBackends())
+	if err != nil {
+		return err
+	}
+
+	// stream control endpoint reads the whole data until the connection is closed
+	conn, err := net.DialTimeout("tcp", streamBackendsAddr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write(data)
+	if err != nil {
+		return err
+	}
+	err = conn.(*net.TCPConn).CloseWrite()
+	if err != nil {
+		return err
+	}
+	reply, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return err
+	}
+	if string(reply) != "OK" {
+		return fmt.Errorf("error configuring stream backends: %s", reply)
+	}
 	return nil
 }
 
+// Health checks whether nginx is serving and holding the backends,
+// the backends are lost if nginx master process has been restarted
+func (n *NginxController) Health() error {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(statusURL)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %v of nginx status", resp.StatusCode)
+	}
+
+	n.mu.RLock()
+	cfg := n.runningConfig
+	n.mu.RUnlock()
+	if cfg == nil {
+		return nil
+	}
+	resp, err = client.Get(httpBackendsURL)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK || len(data) == 0 {
+		return fmt.Errorf("backends are not configured in nginx")
+	}
+	return nil
+}
+
+// Stats returns the traffic stats of each side collected by the lua monitor
+func (n *NginxController) Stats() ([]provider.SideStats, error) {
+	n.mu.RLock()
+	cfg := n.runningConfig
+	n.mu.RUnlock()
+	if cfg == nil {
+		return nil, nil
+	}
+
+	metrics := make(map[string]float64)
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(httpMetricsURL)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %v of http metrics", resp.StatusCode)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&metrics)
+	if err != nil {
+		return nil, fmt.Errorf("Error decode http metrics: %v", err)
+	}
+
+	// stream monitor sends all metrics and closes the connection
+	conn, err := net.DialTimeout("tcp", streamMetricsAddr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	err = json.NewDecoder(conn).Decode(&metrics)
+	if err != nil {
+		return nil, fmt.Errorf("Error decode stream metrics: %v", err)
+	}
+
+	return parseStats(cfg, metrics), nil
+}
+
+// parseStats converts the metrics of the lua monitor to stats of each side.
+// Keys of metrics are upstream|side|metric or upstream|side|latency_bucket|index.
+// Stats of upstreams not in the config are dropped.
+func parseStats(cfg *config.TemplateConfig, metrics map[string]float64) []provider.SideStats {
+	backends := make(map[string]api.L4Backend)
+	for _, svc := range cfg.HTTPBackends {
+		backends[svc.UpstreamName()] = svc.Backend
+	}
+	for _, svc := range cfg.TCPBackends {
+		backends[svc.UpstreamName()] = svc.Backend
+	}
+	for _, svc := range cfg.UDPBackends {
+		backends[svc.UpstreamName()] = svc.Backend
+	}
+
+	stats := make(map[string]*provider.SideStats)
+	for upstream, backend := range backends {
+		for _, side := range []string{provider.SideOrigin, provider.SideCanary} {
+			stats[upstream+"|"+side] = &provider.SideStats{
+				Service: backend.Name,
+				Port:    backend.Port,
+				Side:    side,
+				Latency: provider.Histogram{Buckets: make([]uint64, len(provider.LatencyBuckets))},
+			}
+		}
+	}
+
+	for key, value := range metrics {
+		parts := strings.Split(key, "|")
+		if len(parts) < 3 {
+			continue
+		}
+		s, ok := stats[parts[0]+"|"+parts[1]]
+		if !ok {
+			continue
+		}
+		switch parts[2] {
+		case "requests":
+			s.Requests = uint64(value)
+		case "connections":
+			s.Connections = uint64(value)
+		case "errors":
+			s.Errors = uint64(value)
+		case "failures":
+			s.Failures = uint64(value)
+		case "active":
+			// the gauge goes negative if the key is evicted from the shared dict
+			if value > 0 {
+				s.Active = uint64(value)
+			}
+		case "received_bytes":
+			s.BytesReceived = uint64(value)
+		case "sent_bytes":
+			s.BytesSent = uint64(value)
+		case "latency_sum":
+			s.Latency.Sum = value
+		case "latency_count":
+			s.Latency.Count = uint64(value)
+		case "latency_bucket":
+			if len(parts) < 4 {
+				continue
+			}
+			i, err := strconv.Atoi(parts[3])
+			if err != nil || i < 0 || i >= len(s.Latency.Buckets) {
+				continue
+			}
+			s.Latency.Buckets[i] = uint64(value)
+		}
+	}
+
+	ret := make([]provider.SideStats, 0, len(stats))
+	for _, s := range stats {
+		ret = append(ret, *s)
+	}
+	return ret
+}
+
 // isNginxRunning returns true if a process with the name 'nginx' is found
 func isNginxProcessPresent() bool {
 	processes, _ := ps.Processes()
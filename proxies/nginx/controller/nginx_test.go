@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/caicloud/canary-release/pkg/api"
+	"github.com/caicloud/canary-release/proxies/nginx/config"
+	"github.com/caicloud/canary-release/proxies/provider"
+)
+
+func TestParseStats(t *testing.T) {
+	cfg := &config.TemplateConfig{
+		HTTPBackends: []api.HTTPService{
+			{Port: 8080, Backend: api.L4Backend{Name: "web", Namespace: "test", Port: 80}},
+		},
+		TCPBackends: []api.L4Service{
+			{Port: 8081, Backend: api.L4Backend{Name: "db", Namespace: "test", Port: 3306, Protocol: "TCP"}},
+		},
+	}
+	metrics := map[string]float64{
+		"http-8080-test-web-80|canary|requests":         10,
+		"http-8080-test-web-80|canary|errors":           2,
+		"http-8080-test-web-80|canary|failures":         3,
+		"http-8080-test-web-80|canary|latency_bucket|3": 10,
+		"http-8080-test-web-80|canary|latency_sum":      0.4,
+		"http-8080-test-web-80|canary|latency_count":    10,
+		"tcp-8081-test-db-3306|origin|connections":      3,
+		"tcp-8081-test-db-3306|origin|sent_bytes":       1024,
+		"tcp-8081-test-db-3306|origin|active":           2,
+		"tcp-9999-test-old-3306|origin|connections":     1,
+	}
+
+	stats := parseStats(cfg, metrics)
+	if len(stats) != 4 {
+		t.Fatalf("parseStats() returns %v stats, want 4", len(stats))
+	}
+	for _, s := range stats {
+		switch {
+		case s.Service == "web" && s.Side == provider.SideCanary:
+			if s.Port != 80 || s.Requests != 10 || s.Errors != 2 || s.Failures != 3 || s.Latency.Buckets[3] != 10 || s.Latency.Count != 10 {
+				t.Errorf("parseStats() of web canary = %+v", s)
+			}
+		case s.Service == "db" && s.Side == provider.SideOrigin:
+			if s.Port != 3306 || s.Connections != 3 || s.BytesSent != 1024 || s.Active != 2 {
+				t.Errorf("parseStats() of db origin = %+v", s)
+			}
+		default:
+			if s.Requests != 0 || s.Connections != 0 {
+				t.Errorf("parseStats() of %v %v = %+v, want empty", s.Service, s.Side, s)
+			}
+		}
+	}
+}
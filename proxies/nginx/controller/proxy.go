@@ -9,7 +9,10 @@ import (
 
 	"github.com/caicloud/canary-release/pkg/api"
 	"github.com/caicloud/canary-release/pkg/chart"
+	"github.com/caicloud/canary-release/pkg/election"
+	"github.com/caicloud/canary-release/proxies/health"
 	"github.com/caicloud/canary-release/proxies/nginx/config"
+	"github.com/caicloud/canary-release/proxies/provider"
 	orchestrationlisters "github.com/caicloud/clientset/listers/orchestration/v1alpha1"
 	releaselisters "github.com/caicloud/clientset/listers/release/v1alpha1"
 	orchestrationapi "github.com/caicloud/clientset/pkg/apis/orchestration/v1alpha1"
@@ -54,35 +57,60 @@ type Proxy struct {
 	rLister     releaselisters.ReleaseLister
 	appLister   orchestrationlisters.ApplicationLister
 	svcLister   corelister.ServiceLister
+	epLister    corelister.EndpointsLister
 	crInformer  cache.Controller
 	rInformer   cache.Controller
 	svcInformer cache.Controller
+	epInformer  cache.Controller
 	appInformer cache.Controller
 
-	queue *syncqueue.SyncQueue
+	// configmap tuning the proxy, they are nil if there is no configmap
+	cmLister   corelister.ConfigMapLister
+	cmInformer cache.Controller
 
-	nginx *NginxController
-	codec kube.Codec
+	queue *syncqueue.SyncQueue
 
-	runningConfig *config.TemplateConfig
-	exiting       bool
-	stopCh        chan struct{}
+	provider provider.TrafficProvider
+	prober   *health.Prober
+	elector  *election.Elector
+	codec    kube.Codec
+
+	runningSplit *provider.Split
+	// syncedSpec is the spec of the last sync, a paused canary release is synced with it
+	syncedSpec *releaseapi.CanaryReleaseSpec
+	// syncedAsLeader is true if the running split is synced by the leader
+	syncedAsLeader bool
+	exiting        bool
+	stopCh         chan struct{}
+
+	// failed tries to canary at the last failover check
+	canaryFailures uint64
+	canaryFailing  bool
+
+	// drainStartTime is when canary started draining, it's zero if not draining
+	drainStartTime time.Time
+
+	// traffic stats and time of the last comparison
+	comparedStats []provider.SideStats
+	comparedTime  time.Time
 }
 
 // NewProxy ...
-func NewProxy(cfg config.Configuration) *Proxy {
+func NewProxy(cfg config.Configuration, tp provider.TrafficProvider) *Proxy {
 	p := &Proxy{
 		cfg:           cfg,
 		namespace:     cfg.CanaryReleaseNamespace,
 		canaryrelease: cfg.CanaryReleaseName,
 		release:       cfg.ReleaseName,
-		nginx:         NewNginxController(),
+		provider:      tp,
 		codec:         cfg.Codec,
 		stopCh:        make(chan struct{}),
 	}
+	p.prober = health.NewProber(p.healthChanged)
+	p.elector = p.newElector()
 
 	namespace := cfg.CanaryReleaseNamespace
-	var crIndexer, rIndexer, svcIndexer, appIndexer cache.Indexer
+	var crIndexer, rIndexer, svcIndexer, epIndexer, appIndexer cache.Indexer
 
 	// construct canary release informer
 	crIndexer, p.crInformer = cache.NewIndexerInformer(
@@ -154,12 +182,38 @@ func NewProxy(cfg config.Configuration) *Proxy {
 		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
 	)
 
+	// construct endpoints informer
+	epIndexer, p.epInformer = cache.NewIndexerInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return cfg.Client.CoreV1().Endpoints(namespace).List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return cfg.Client.CoreV1().Endpoints(namespace).Watch(options)
+			},
+		},
+		&core.Endpoints{},
+		0,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    p.addEndpoints,
+			UpdateFunc: p.updateEndpoints,
+			DeleteFunc: p.deleteEndpoints,
+		},
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+	)
+
 	p.queue = syncqueue.NewPassthroughSyncQueue(&releaseapi.CanaryRelease{}, p.syncCanaryRelease)
 	p.crLister = releaselisters.NewCanaryReleaseLister(crIndexer)
 	p.rLister = releaselisters.NewReleaseLister(rIndexer)
 	p.svcLister = corelister.NewServiceLister(svcIndexer)
+	p.epLister = corelister.NewEndpointsLister(epIndexer)
 	p.appLister = orchestrationlisters.NewApplicationLister(appIndexer)
 
+	// construct configmap informer, only the configmap of proxy is watched
+	if cmNamespace, cmName := p.configMapOf(); cmName != "" {
+		p.cmLister, p.cmInformer = p.newConfigMapInformer(cmNamespace, cmName)
+	}
+
 	return p
 }
 
@@ -174,15 +228,22 @@ func (p *Proxy) Run(workers int) {
 	go p.crInformer.Run(p.stopCh)
 	go p.rInformer.Run(p.stopCh)
 	go p.svcInformer.Run(p.stopCh)
+	go p.epInformer.Run(p.stopCh)
 	go p.appInformer.Run(p.stopCh)
-
-	log.Info("Wart for all caches synced")
-	if !cache.WaitForCacheSync(p.stopCh,
+	synced := []cache.InformerSynced{
 		p.crInformer.HasSynced,
 		p.rInformer.HasSynced,
 		p.svcInformer.HasSynced,
+		p.epInformer.HasSynced,
 		p.appInformer.HasSynced,
-	) {
+	}
+	if p.cmInformer != nil {
+		go p.cmInformer.Run(p.stopCh)
+		synced = append(synced, p.cmInformer.HasSynced)
+	}
+
+	log.Info("Wart for all caches synced")
+	if !cache.WaitForCacheSync(p.stopCh, synced...) {
 		log.Error("wait for cache sync timeout")
 		return
 	}
@@ -194,11 +255,22 @@ func (p *Proxy) Run(workers int) {
 		"release":   p.release,
 	})
 
+	// elect a leader among replicas to change resources in cluster
+	if p.elector != nil {
+		go p.elector.Run(p.stopCh)
+	}
+
 	// start workers
 	p.queue.Run(workers)
 
-	// start nginx
-	go p.nginx.Start()
+	// start traffic provider
+	go p.provider.Start()
+
+	// report failover of canary
+	go wait.Until(p.checkFailover, failoverCheckPeriod, p.stopCh)
+
+	// compare canary with origin
+	go wait.Until(p.checkComparison, comparisonCheckPeriod, p.stopCh)
 
 	<-p.stopCh
 }
@@ -211,8 +283,9 @@ func (p *Proxy) Stop() error {
 	close(p.stopCh)
 	// stop queue
 	p.queue.ShutDown()
-	// stop nginx
-	_ = p.nginx.Stop()
+	// stop health checks and traffic provider
+	p.prober.Stop()
+	_ = p.provider.Stop()
 	return nil
 }
 
@@ -363,6 +436,9 @@ func (p *Proxy) deleteRelease(obj interface{}) {
 // deprecate changes the canary release's transition to Deprecated
 // and defers the processing to next iteration.
 func (p *Proxy) deprecate(cr *releaseapi.CanaryRelease) error {
+	if !p.isLeader() {
+		return nil
+	}
 
 	// if Transition is not None, skip it
 	if cr.Spec.Transition != releaseapi.CanaryTrasitionNone {
@@ -445,10 +521,21 @@ func (p *Proxy) syncCanaryRelease(obj interface{}) error {
 	cr = ncr.DeepCopy()
 
 	if cr.Spec.Transition != releaseapi.CanaryTrasitionNone {
+		if gated, err := p.adoptionGated(cr); gated || err != nil {
+			log.Info("Adoption waits for gates", log.Fields{"cr": key})
+			return err
+		}
 		log.Info("detected a adopted/deprecated Cananry Release, cleanup it", log.Fields{"cr": key})
 		return p.cleanup(cr)
 	}
 
+	// a paused canary release keeps the traffic split, changes to spec
+	// are ignored until it's resumed
+	if spec := p.pausedSpec(cr); spec != nil {
+		log.Info("CanaryRelease is paused, sync it with the paused spec", log.Fields{"cr": key})
+		cr.Spec = *spec
+	}
+
 	// find related release
 	release, err := p.rLister.Releases(cr.Namespace).Get(cr.Spec.Release)
 	if errors.IsNotFound(err) {
@@ -468,16 +555,38 @@ func (p *Proxy) syncCanaryRelease(obj interface{}) error {
 		return p.deprecate(cr)
 	}
 
-	return p.sync(cr, release)
+	if err := p.sync(cr, release); err != nil {
+		return err
+	}
+	p.syncedSpec = cr.Spec.DeepCopy()
+	return nil
+}
+
+// pausedSpec returns the spec to sync a paused canary release with, it's nil
+// if the canary release is not paused. The spec frozen in status by the
+// controller is preferred, it survives restarts and changes of the leader.
+// The last synced spec is used until the controller freezes one.
+func (p *Proxy) pausedSpec(cr *releaseapi.CanaryRelease) *releaseapi.CanaryReleaseSpec {
+	if !cr.Spec.Paused {
+		return nil
+	}
+	if cr.Status.PausedSpec != nil {
+		return cr.Status.PausedSpec.DeepCopy()
+	}
+	return p.syncedSpec.DeepCopy()
 }
 
+// sync applies the canary release and records the result in a condition.
+// It runs on every change of endpoints, health and configmaps, so only a
+// result different from the last condition is recorded.
 func (p *Proxy) sync(cr *releaseapi.CanaryRelease, release *releaseapi.Release) error {
-	_ = p.addCondition(cr, api.NewCondition(api.ReasonUpdating, ""))
 	err := p._sync(cr, release)
+	condition := api.NewCondition(api.ReasonAvailable, "")
 	if err != nil {
-		_ = p.addCondition(cr, api.NewConditionFrom(err))
-	} else {
-		_ = p.addCondition(cr, api.NewCondition(api.ReasonAvailable, ""))
+		condition = api.NewConditionFrom(err)
+	}
+	if !api.IsLastCondition(cr, condition.Reason, condition.Message) {
+		_ = p.addCondition(cr, condition)
 	}
 	return err
 }
@@ -488,7 +597,7 @@ func (p *Proxy) _sync(cr *releaseapi.CanaryRelease, release *releaseapi.Release)
 	// find origin service
 	// fork origin service and change it's name, add owner reference
 	// find canay release service and change it's name
-	// update nginx config
+	// apply traffic split
 	// find original service change it's target port and selector
 
 	canaryOwner := renderOwnerReference(cr)
@@ -517,68 +626,93 @@ func (p *Proxy) _sync(cr *releaseapi.CanaryRelease, release *releaseapi.Release)
 	lastManifest := render.SplitManifest(cr.Status.Manifest)
 	// service in canary objects have been changed
 	manifest, _ := p.codec.ObjectsToResources(canaryObj)
+	leader := p.isLeader()
 	if reflect.DeepEqual(lastManifest, manifest) {
-		log.Info("manifest is not changed, skip this sync")
-		return nil
-	}
-	err = p.cfg.ReleaseClient.Update(cr.Namespace, lastManifest, manifest, kube.UpdateOptions{
-		OwnerReferences: []metav1.OwnerReference{
-			canaryOwner,
-			releaseOwner,
-		},
-	})
-	if err != nil {
-		log.Errorf("Error update manifest, err: %v", err)
-		return err
-	}
+		// weights or endpoints may still be changed
+		log.Info("manifest is not changed, skip updating manifest")
+	} else if !leader {
+		log.Info("not the leader, skip updating manifest")
+	} else {
+		err = p.cfg.ReleaseClient.Update(cr.Namespace, lastManifest, manifest, kube.UpdateOptions{
+			OwnerReferences: []metav1.OwnerReference{
+				canaryOwner,
+				releaseOwner,
+			},
+		})
+		if err != nil {
+			log.Errorf("Error update manifest, err: %v", err)
+			return err
+		}
 
-	// update status - patch manifest
-	patch, _ := json.Marshal(jsonMap{
-		"status": jsonMap{
-			"manifest": render.MergeResources(manifest),
-		},
-	})
-	_, err = p.cfg.Client.ReleaseV1alpha1().CanaryReleases(p.namespace).Patch(p.canaryrelease, types.MergePatchType, patch)
+		// update status - patch manifest
+		patch, _ := json.Marshal(jsonMap{
+			"status": jsonMap{
+				"manifest": render.MergeResources(manifest),
+			},
+		})
+		_, err = p.cfg.Client.ReleaseV1alpha1().CanaryReleases(p.namespace).Patch(p.canaryrelease, types.MergePatchType, patch)
 
-	if err != nil {
-		log.Errorf("Error update canary release status.manifest, %v", err)
-		return err
+		if err != nil {
+			log.Errorf("Error update canary release status.manifest, %v", err)
+			return err
+		}
 	}
 
 	// Step 4
-	// get tcp and udp upstream
-	nginxConfig := config.NewDefaultTemplateConfig()
-	nginxConfig.TCPBackends, nginxConfig.UDPBackends = p.getUpsteamService(svcCol)
+	// probe the sides of ports, traffic of unhealthy sides goes to the other side
+	p.prober.Update(p.healthTargets(svcCol))
+	if leader {
+		if err := p.syncHealthStatus(cr); err != nil {
+			log.Error("Error update canary release health status", log.Fields{"err": err})
+		}
+	}
 
-	// check if need to update
-	if p.runningConfig != nil && p.runningConfig.Equal(&nginxConfig) {
-		log.Info("template config is not changed")
-		return nil
+	// get http, tcp and udp upstream
+	split := provider.Split{}
+	split.HTTP, split.TCP, split.UDP = p.getUpsteamService(svcCol)
+	split.Options = p.getConfigMapData()
+
+	// check if need to update, an unhealthy provider may lose the running split.
+	// A new leader syncs services even if the split is not changed.
+	if p.runningSplit != nil && p.runningSplit.Equal(&split) && (p.syncedAsLeader || !leader) {
+		herr := p.provider.Health()
+		if herr == nil {
+			log.Info("traffic split is not changed")
+			return nil
+		}
+		log.Warn("Traffic provider is unhealthy, apply the split again", log.Fields{"err": herr})
 	}
 
 	// Step 5
-	// try to create forked service
-	for _, svccol := range svcCol {
-		// add owner reference to service
-		svccol.forked.OwnerReferences = appendOwnerIfNotExists(svccol.forked.OwnerReferences, canaryOwner)
-		_, err := p.cfg.Client.CoreV1().Services(p.namespace).Create(svccol.forked)
-		if errors.IsAlreadyExists(err) {
-			continue
-		}
-		if err != nil {
-			err = fmt.Errorf("Error create forked service, err: %v", err)
-			return err
+	// try to create forked service, followers leave it to the leader
+	if leader {
+		for _, svccol := range svcCol {
+			// add owner reference to service
+			svccol.forked.OwnerReferences = appendOwnerIfNotExists(svccol.forked.OwnerReferences, canaryOwner)
+			_, err := p.cfg.Client.CoreV1().Services(p.namespace).Create(svccol.forked)
+			if errors.IsAlreadyExists(err) {
+				continue
+			}
+			if err != nil {
+				err = fmt.Errorf("Error create forked service, err: %v", err)
+				return err
+			}
 		}
 	}
 
 	// Step 6
-	// update nginx config
-	err = p.nginx.OnUpdate(nginxConfig)
+	// apply traffic split
+	err = p.provider.Apply(split)
 	if err != nil {
-		err = fmt.Errorf("Error reload nginx, err: %v", err)
+		err = fmt.Errorf("Error apply traffic split, err: %v", err)
 		log.Error(err)
 		return err
 	}
+	if !leader {
+		p.runningSplit = &split
+		p.syncedAsLeader = false
+		return nil
+	}
 
 	// Step 6
 	// update original service
@@ -615,12 +749,13 @@ func (p *Proxy) _sync(cr *releaseapi.CanaryRelease, release *releaseapi.Release)
 		}
 
 	}
-	// set running config
-	p.runningConfig = &nginxConfig
+	// set running split
+	p.runningSplit = &split
+	p.syncedAsLeader = true
 	return nil
 }
 
-func (p *Proxy) getUpsteamService(svcCol []*serviceCollection) ([]api.L4Service, []api.L4Service) {
+func (p *Proxy) getUpsteamService(svcCol []*serviceCollection) ([]api.HTTPService, []api.L4Service, []api.L4Service) {
 
 	getWeight := func(weight *int32) (int32, int32) {
 		if weight == nil {
@@ -642,6 +777,7 @@ func (p *Proxy) getUpsteamService(svcCol []*serviceCollection) ([]api.L4Service,
 	cols := sortByName(svcCol)
 	sort.Sort(cols)
 
+	var httpService []api.HTTPService
 	var tcpService, udpService []api.L4Service
 
 	for _, col := range cols {
@@ -654,40 +790,74 @@ func (p *Proxy) getUpsteamService(svcCol []*serviceCollection) ([]api.L4Service,
 		}
 
 		for _, port := range ports {
-			// HTTP HTTPS TCP are considered as TCP protocol
+			// HTTP HTTPS TCP are all TCP protocol in kubernetes service
 			protocol := core.ProtocolTCP
 			if port.Protocol == releaseapi.ProtocolUDP {
 				protocol = core.ProtocolUDP
 			}
 
-			canaryWeight, originWeight := getWeight(port.Config.Weight)
-
-			upsteam := api.L4Service{
-				Port: upsteamPort,
-				Backend: api.L4Backend{
-					Port:      port.Port,
-					Name:      col.name,
-					Namespace: p.namespace,
-					Protocol:  protocol,
-				},
-				Endpoints: []api.Endpoint{
-					{
-						Address: col.forked.Name,
-						Port:    port.Port,
-						Weight:  originWeight,
-					},
-					{
-						Address: col.canary.Name,
-						Port:    port.Port,
-						Weight:  canaryWeight,
-					},
-				},
+			if ignored := ignoredConfig(port.Protocol, port.Config); len(ignored) > 0 {
+				log.Warn("Canary config is ignored on the port", log.Fields{"svc": col.name, "port": port.Port, "protocol": port.Protocol, "ignored": ignored})
 			}
 
-			if protocol == core.ProtocolTCP {
-				tcpService = append(tcpService, upsteam)
-			} else {
-				udpService = append(udpService, upsteam)
+			canaryWeight, originWeight := getWeight(port.Config.Weight)
+			canaryWeight, originWeight = p.healthyWeights(col.name, port.Port, canaryWeight, originWeight)
+			canaryUnhealthy := p.canaryUnhealthy(col.name, port.Port)
+
+			backend := api.L4Backend{
+				Port:      port.Port,
+				Name:      col.name,
+				Namespace: p.namespace,
+				Protocol:  protocol,
+			}
+			endpoints := p.getEndpoints(col, protocol, port.Port, originWeight, canaryWeight)
+
+			switch {
+			case port.Protocol == releaseapi.ProtocolHTTP || port.Protocol == releaseapi.ProtocolGRPC:
+				// HTTP and gRPC are proxied in L7, the weight is applied to each request.
+				// HTTPS is still proxied in L4 because the proxy does not hold
+				// the certificates to terminate TLS, it can only be routed by SNI
+				service := api.HTTPService{
+					Port:      upsteamPort,
+					Backend:   backend,
+					Endpoints: endpoints,
+					GRPC:      port.Protocol == releaseapi.ProtocolGRPC,
+				}
+				if service.GRPC {
+					// cookies and mirror are meaningless to gRPC
+					service.Rules = getHTTPRules(port.Config.Rules)
+				} else if mirror, _ := getWeight(port.Config.Mirror); mirror > 0 {
+					// in mirror mode, origin serves all requests
+					service.MirrorPercent = mirror
+				} else {
+					service.Rules = getHTTPRules(port.Config.Rules)
+					service.Stickiness = getHTTPStickiness(port.Config.Stickiness, col.name, port.Port, canaryWeight)
+				}
+				if canaryUnhealthy {
+					service = originOnlyHTTP(service)
+				}
+				httpService = append(httpService, service)
+			case protocol == core.ProtocolTCP:
+				service := api.L4Service{
+					Port:      upsteamPort,
+					Backend:   backend,
+					Endpoints: endpoints,
+					HashKey:   getHashKey(port.Config.Affinity),
+				}
+				if port.Protocol == releaseapi.ProtocolHTTPS {
+					service.ServerNames = getServerNames(port.Config.Hosts)
+				}
+				if canaryUnhealthy {
+					service = originOnlyL4(service)
+				}
+				tcpService = append(tcpService, service)
+			default:
+				udpService = append(udpService, api.L4Service{
+					Port:      upsteamPort,
+					Backend:   backend,
+					Endpoints: endpoints,
+					HashKey:   getHashKey(port.Config.Affinity),
+				})
 			}
 
 			col.protoPort2upstreamPort[protoPortKey(protocol, port.Port)] = upsteamPort
@@ -696,7 +866,7 @@ func (p *Proxy) getUpsteamService(svcCol []*serviceCollection) ([]api.L4Service,
 		}
 	}
 
-	return tcpService, udpService
+	return httpService, tcpService, udpService
 }
 
 // render all needed services
@@ -707,6 +877,7 @@ func (p *Proxy) renderService(originObj, canaryObj []runtime.Object, cr *release
 		var err error
 		// add canary service config
 		s := &serviceCollection{
+			name:    svc.Service,
 			service: svc,
 		}
 
@@ -822,6 +993,9 @@ func (p *Proxy) renderCanaryRelease(release *releaseapi.Release, cr *releaseapi.
 
 func (p *Proxy) cleanup(cr *releaseapi.CanaryRelease) error {
 	err := p._cleanup(cr)
+	if err == errDraining {
+		return nil
+	}
 	if err != nil {
 		_ = p.addErrorCondition(cr, err)
 	} else {
@@ -832,7 +1006,12 @@ func (p *Proxy) cleanup(cr *releaseapi.CanaryRelease) error {
 
 func (p *Proxy) _cleanup(cr *releaseapi.CanaryRelease) error {
 	if cr.Status.Phase != releaseapi.CanaryTrasitionNone {
-		// transition finished
+		// transition finished, followers waiting for gates of adoption
+		// exit after the leader
+		if !p.isLeader() {
+			p.runningSplit = nil
+			p.exiting = true
+		}
 		return nil
 	}
 
@@ -842,6 +1021,18 @@ func (p *Proxy) _cleanup(cr *releaseapi.CanaryRelease) error {
 		transition = releaseapi.CanaryTrasitionDeprecated
 	}
 
+	// let connections to canary finish before deleting it
+	if transition == releaseapi.CanaryTrasitionDeprecated && !p.drainCanary(cr) {
+		return errDraining
+	}
+
+	if !p.isLeader() {
+		// followers only drain their own traffic, the leader cleans up resources
+		p.runningSplit = nil
+		p.exiting = true
+		return nil
+	}
+
 	canaryOwner := renderOwnerReference(cr)
 	rClient := p.cfg.Client.ReleaseV1alpha1().Releases(cr.Namespace)
 	crClient := p.cfg.Client.ReleaseV1alpha1().CanaryReleases(cr.Namespace)
@@ -1022,7 +1213,6 @@ func (p *Proxy) _cleanup(cr *releaseapi.CanaryRelease) error {
 		deleteSvcs(canaryService)
 
 	} else if transition == releaseapi.CanaryTrasitionDeprecated {
-
 		// maybe release has been deleted, the originalService will be empty
 		// use forked service cover original
 		_, _, forkedService, err := getRelatedAndRecoverSvcs(forkedServiceSuffix, true, getAndrecoverSvcFunc{getSvcs, recoverSvcs})
@@ -1042,7 +1232,7 @@ func (p *Proxy) _cleanup(cr *releaseapi.CanaryRelease) error {
 
 	patch := fmt.Sprintf(`{"status":{"manifest":null,"phase":"%s"}}`, cr.Spec.Transition)
 	_, _ = crClient.Patch(cr.Name, types.MergePatchType, []byte(patch))
-	p.runningConfig = nil
+	p.runningSplit = nil
 	p.exiting = true
 	return nil
 }
@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+)
+
+func TestPausedSpec(t *testing.T) {
+	weight := func(w int32) releaseapi.CanaryReleaseSpec {
+		return releaseapi.CanaryReleaseSpec{
+			Release: "web",
+			Paused:  true,
+			Service: []releaseapi.CanaryService{
+				{
+					Service: "web",
+					Ports: []releaseapi.CanaryPort{
+						{Port: 80, Protocol: releaseapi.ProtocolHTTP, Config: releaseapi.CanaryConfig{Weight: &w}},
+					},
+				},
+			},
+		}
+	}
+	frozen, synced, edited := weight(10), weight(20), weight(50)
+	resumed := edited
+	resumed.Paused = false
+
+	tests := []struct {
+		name   string
+		synced *releaseapi.CanaryReleaseSpec
+		spec   releaseapi.CanaryReleaseSpec
+		paused *releaseapi.CanaryReleaseSpec
+		want   *releaseapi.CanaryReleaseSpec
+	}{
+		{"not paused", &synced, resumed, nil, nil},
+		{"paused before frozen", &synced, edited, nil, &synced},
+		{"frozen", &synced, edited, &frozen, &frozen},
+		// a restarted proxy or a new leader has synced nothing
+		{"restarted while paused", nil, edited, &frozen, &frozen},
+		{"restarted before frozen", nil, edited, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Proxy{syncedSpec: tt.synced}
+			cr := &releaseapi.CanaryRelease{}
+			cr.Spec = tt.spec
+			cr.Status.PausedSpec = tt.paused
+			if got := p.pausedSpec(cr); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pausedSpec() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,15 +2,18 @@ package controller
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 	"syscall"
 
+	"github.com/caicloud/canary-release/pkg/api"
 	"github.com/caicloud/canary-release/pkg/util"
 	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
 	"github.com/golang/glog"
 	core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/kubernetes/pkg/util/sysctl"
 )
@@ -73,6 +76,23 @@ func (s sortByPort) Swap(i, j int) {
 	s[i], s[j] = s[j], s[i]
 }
 
+type sortByAddress []api.Endpoint
+
+func (s sortByAddress) Len() int {
+	return len(s)
+}
+
+func (s sortByAddress) Less(i, j int) bool {
+	if s[i].Address != s[j].Address {
+		return s[i].Address < s[j].Address
+	}
+	return s[i].Port < s[j].Port
+}
+
+func (s sortByAddress) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
 // sysctlSomaxconn returns the value of net.core.somaxconn, i.e.
 // maximum number of connections that can be queued for acceptance
 // http://nginx.org/en/docs/http/ngx_http_core_module.html#listen
@@ -99,11 +119,138 @@ func sysctlFSFileMax() int {
 	return int(rLimit.Max)
 }
 
+// gcd returns the greatest common divisor of a and b
+func gcd(a, b int32) int32 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
 // protoPortKey generate key for protocol port
 func protoPortKey(protocol core.Protocol, port int32) string {
 	return fmt.Sprintf("%s-%d", protocol, port)
 }
 
+// getHTTPRules converts canary rules to http rules, rules without header are
+// ignored. The header and regex are rendered into nginx.conf, so rules with
+// an invalid header name or regex are dropped. Regexes are checked by RE2,
+// which is a subset of PCRE used by nginx.
+func getHTTPRules(rules []releaseapi.CanaryRule) []api.HTTPRule {
+	var ret []api.HTTPRule
+	for _, rule := range rules {
+		if rule.Header == "" {
+			continue
+		}
+		if !isHeaderName(rule.Header) {
+			glog.Warningf("ignore rule with invalid header name %q", rule.Header)
+			continue
+		}
+		if rule.Value == "" && rule.Regex != "" {
+			if _, err := regexp.Compile(rule.Regex); err != nil {
+				glog.Warningf("ignore rule with invalid regex %q: %v", rule.Regex, err)
+				continue
+			}
+		}
+		ret = append(ret, api.HTTPRule{
+			Header: rule.Header,
+			Value:  rule.Value,
+			Regex:  rule.Regex,
+		})
+	}
+	return ret
+}
+
+// isHeaderName returns true if the header name only contains letters, digits and hyphens
+func isHeaderName(header string) bool {
+	for _, r := range header {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return header != ""
+}
+
+// getHTTPStickiness converts canary stickiness to http stickiness,
+// the cookie name defaults to canary_<service>_<port>
+func getHTTPStickiness(stickiness *releaseapi.CanaryStickiness, service string, port, canaryWeight int32) *api.HTTPStickiness {
+	if stickiness == nil {
+		return nil
+	}
+	cookie := stickiness.Cookie
+	if cookie == "" {
+		cookie = fmt.Sprintf("canary_%s_%d", service, port)
+	}
+	// nginx variable $cookie_<name> only accepts letters, digits and underscores
+	cookie = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, cookie)
+
+	maxAge := stickiness.MaxAge
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	return &api.HTTPStickiness{
+		Cookie:       cookie,
+		MaxAge:       maxAge,
+		CanaryWeight: canaryWeight,
+	}
+}
+
+// ignoredConfig returns the fields of canary config ignored on the port,
+// rules and stickiness are ignored in mirror mode, mirror and stickiness
+// are meaningless to gRPC
+func ignoredConfig(protocol releaseapi.Protocol, config releaseapi.CanaryConfig) []string {
+	var ignored []string
+	if protocol == releaseapi.ProtocolGRPC {
+		if config.Mirror != nil {
+			ignored = append(ignored, "mirror")
+		}
+		if config.Stickiness != nil {
+			ignored = append(ignored, "stickiness")
+		}
+	}
+	if protocol == releaseapi.ProtocolHTTP && config.Mirror != nil {
+		if len(config.Rules) > 0 {
+			ignored = append(ignored, "rules")
+		}
+		if config.Stickiness != nil {
+			ignored = append(ignored, "stickiness")
+		}
+	}
+	return ignored
+}
+
+// getHashKey returns the consistent hashing key of the affinity
+func getHashKey(affinity releaseapi.CanaryAffinity) string {
+	if affinity == releaseapi.CanaryAffinityClientIP {
+		return "$remote_addr"
+	}
+	return ""
+}
+
+// getServerNames returns the normalized TLS server names, empty names are
+// ignored. Names are rendered into nginx.conf, so only DNS subdomains with
+// an optional leading wildcard are kept.
+func getServerNames(hosts []string) []string {
+	var ret []string
+	for _, host := range hosts {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host == "" {
+			continue
+		}
+		if errs := validation.IsDNS1123Subdomain(strings.TrimPrefix(host, "*.")); len(errs) > 0 {
+			glog.Warningf("ignore invalid host %q: %v", host, strings.Join(errs, ", "))
+			continue
+		}
+		ret = append(ret, host)
+	}
+	return ret
+}
+
 func getService(objs []runtime.Object, svcName string) (*core.Service, error) {
 	for _, o := range objs {
 		svc, ok := o.(*core.Service)
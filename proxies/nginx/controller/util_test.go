@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/caicloud/canary-release/pkg/api"
+	releaseapi "github.com/caicloud/clientset/pkg/apis/release/v1alpha1"
+)
+
+func TestGetHTTPRules(t *testing.T) {
+	rules := []releaseapi.CanaryRule{
+		{Header: "X-Canary", Value: "always"},
+		{Header: "", Value: "empty"},
+		{Header: "X-Canary; proxy_pass http://evil", Value: "1"},
+		{Header: "X Canary", Value: "1"},
+		{Header: "X-{Canary}", Value: "1"},
+		{Header: "X-$Canary", Value: "1"},
+		{Header: "x-user-id", Regex: "^1"},
+		{Header: "X-User", Regex: "^(qa"},
+		{Header: "X-User", Regex: `^qa-\d+"`},
+		{Header: "X-Group", Value: "beta", Regex: "[invalid"},
+	}
+	want := []api.HTTPRule{
+		{Header: "X-Canary", Value: "always"},
+		{Header: "x-user-id", Regex: "^1"},
+		{Header: "X-User", Regex: `^qa-\d+"`},
+		// regex is ignored if value is set
+		{Header: "X-Group", Value: "beta", Regex: "[invalid"},
+	}
+	if got := getHTTPRules(rules); !reflect.DeepEqual(got, want) {
+		t.Errorf("getHTTPRules() = %+v, want %+v", got, want)
+	}
+}
+
+func TestGetServerNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		hosts []string
+		want  []string
+	}{
+		{"none", nil, nil},
+		{"normalized", []string{" Example.COM ", ""}, []string{"example.com"}},
+		{"wildcard", []string{"*.example.com"}, []string{"*.example.com"}},
+		{"invalid", []string{"a.com; b.com", "a .com", "*", "a.*.com", "{a}.com", "ok.com"}, []string{"ok.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getServerNames(tt.hosts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getServerNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIgnoredConfig(t *testing.T) {
+	mirror := int32(10)
+	rules := []releaseapi.CanaryRule{{Header: "X-Canary", Value: "always"}}
+	stickiness := &releaseapi.CanaryStickiness{}
+
+	tests := []struct {
+		name     string
+		protocol releaseapi.Protocol
+		config   releaseapi.CanaryConfig
+		want     []string
+	}{
+		{"http", releaseapi.ProtocolHTTP, releaseapi.CanaryConfig{Rules: rules, Stickiness: stickiness}, nil},
+		{"mirror only", releaseapi.ProtocolHTTP, releaseapi.CanaryConfig{Mirror: &mirror}, nil},
+		{"mirror with rules", releaseapi.ProtocolHTTP, releaseapi.CanaryConfig{Mirror: &mirror, Rules: rules}, []string{"rules"}},
+		{"mirror with all", releaseapi.ProtocolHTTP, releaseapi.CanaryConfig{Mirror: &mirror, Rules: rules, Stickiness: stickiness}, []string{"rules", "stickiness"}},
+		{"grpc rules", releaseapi.ProtocolGRPC, releaseapi.CanaryConfig{Rules: rules}, nil},
+		{"grpc with all", releaseapi.ProtocolGRPC, releaseapi.CanaryConfig{Mirror: &mirror, Rules: rules, Stickiness: stickiness}, []string{"mirror", "stickiness"}},
+		{"tcp", releaseapi.ProtocolTCP, releaseapi.CanaryConfig{Mirror: &mirror, Stickiness: stickiness}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ignoredConfig(tt.protocol, tt.config); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ignoredConfig() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetHTTPStickiness(t *testing.T) {
+	tests := []struct {
+		name       string
+		stickiness *releaseapi.CanaryStickiness
+		want       *api.HTTPStickiness
+	}{
+		{"disabled", nil, nil},
+		{
+			"default cookie",
+			&releaseapi.CanaryStickiness{},
+			&api.HTTPStickiness{Cookie: "canary_web_8080", CanaryWeight: 10},
+		},
+		{
+			"cookie and max age",
+			&releaseapi.CanaryStickiness{Cookie: "canary", MaxAge: 3600},
+			&api.HTTPStickiness{Cookie: "canary", MaxAge: 3600, CanaryWeight: 10},
+		},
+		{
+			"invalid characters",
+			&releaseapi.CanaryStickiness{Cookie: "canary-user.id;$x {}"},
+			&api.HTTPStickiness{Cookie: "canary_user_id__x___", CanaryWeight: 10},
+		},
+		{
+			"negative max age",
+			&releaseapi.CanaryStickiness{Cookie: "canary", MaxAge: -1},
+			&api.HTTPStickiness{Cookie: "canary", CanaryWeight: 10},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getHTTPStickiness(tt.stickiness, "web", 8080, 10); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getHTTPStickiness() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetHashKey(t *testing.T) {
+	tests := []struct {
+		affinity releaseapi.CanaryAffinity
+		want     string
+	}{
+		{releaseapi.CanaryAffinityNone, ""},
+		{releaseapi.CanaryAffinityClientIP, "$remote_addr"},
+		{"clientip", ""},
+		{"Unknown", ""},
+	}
+	for _, tt := range tests {
+		if got := getHashKey(tt.affinity); got != tt.want {
+			t.Errorf("getHashKey(%q) = %q, want %q", tt.affinity, got, tt.want)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"strings"
 	textTemplate "text/template"
 
+	"github.com/caicloud/canary-release/pkg/api"
 	"github.com/caicloud/canary-release/proxies/nginx/config"
 	ingressConfig "github.com/caicloud/canary-release/third_party/ingress/controllers/nginx/pkg/config"
 	"github.com/caicloud/canary-release/third_party/ingress/core/pkg/watch"
@@ -49,6 +50,8 @@ var (
 		"toLower":                strings.ToLower,
 		"formatIP":               formatIP,
 		"buildNextUpstream":      buildNextUpstream,
+		"buildHeaderVariable":    buildHeaderVariable,
+		"buildRuleMatch":         buildRuleMatch,
 	}
 )
 
@@ -162,3 +165,38 @@ func buildNextUpstream(input interface{}) string {
 
 	return strings.Join(nextUpstreamCodes, " ")
 }
+
+// buildHeaderVariable returns the nginx variable of the request header,
+// e.g. X-Canary => $http_x_canary
+func buildHeaderVariable(header string) string {
+	return "$http_" + strings.Replace(strings.ToLower(header), "-", "_", -1)
+}
+
+// buildRuleMatch returns the quoted source value used in nginx map
+// for the given rule.
+func buildRuleMatch(input interface{}) string {
+	rule, ok := input.(api.HTTPRule)
+	if !ok {
+		glog.Errorf("expected an api.HTTPRule type but %T was returned", input)
+		return `"~."`
+	}
+
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+	var match string
+	switch {
+	case rule.Value != "":
+		match = escape.Replace(rule.Value)
+		// a leading tilde means regular expression in nginx map
+		if strings.HasPrefix(match, "~") {
+			match = `\` + match
+		}
+	case rule.Regex != "":
+		match = "~" + escape.Replace(rule.Regex)
+	default:
+		// match any non-empty value
+		match = "~."
+	}
+
+	return `"` + match + `"`
+}
@@ -0,0 +1,126 @@
+package template
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	textTemplate "text/template"
+
+	"github.com/caicloud/canary-release/pkg/api"
+	"github.com/caicloud/canary-release/proxies/nginx/config"
+	core "k8s.io/api/core/v1"
+)
+
+func TestBuildRuleMatch(t *testing.T) {
+	tests := []struct {
+		name string
+		rule api.HTTPRule
+		want string
+	}{
+		{
+			"exact value",
+			api.HTTPRule{Header: "X-Canary", Value: "always"},
+			`"always"`,
+		},
+		{
+			"exact value with leading tilde",
+			api.HTTPRule{Header: "X-Canary", Value: "~always"},
+			`"\~always"`,
+		},
+		{
+			"value takes precedence over regex",
+			api.HTTPRule{Header: "X-Canary", Value: "always", Regex: "^qa-"},
+			`"always"`,
+		},
+		{
+			"regex",
+			api.HTTPRule{Header: "X-User", Regex: `^qa-\d+"`},
+			`"~^qa-\\d+\""`,
+		},
+		{
+			"any value",
+			api.HTTPRule{Header: "X-Canary"},
+			`"~."`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildRuleMatch(tt.rule); got != tt.want {
+				t.Errorf("buildRuleMatch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildHeaderVariable(t *testing.T) {
+	if got := buildHeaderVariable("X-Canary-User"); got != "$http_x_canary_user" {
+		t.Errorf("buildHeaderVariable() = %v, want %v", got, "$http_x_canary_user")
+	}
+}
+
+func TestRenderGRPC(t *testing.T) {
+	tmpl, err := textTemplate.New("nginx.tmpl").Funcs(funcMap).ParseFiles("../../../build/nginx-proxy/etc/nginx/template/nginx.tmpl")
+	if err != nil {
+		t.Fatalf("Error parse template: %v", err)
+	}
+	service := func(port int32, grpc bool, rules []api.HTTPRule) api.HTTPService {
+		return api.HTTPService{
+			Port:    port,
+			Backend: api.L4Backend{Port: 50051, Name: "greeter", Namespace: "test", Protocol: core.ProtocolTCP},
+			Endpoints: []api.Endpoint{
+				{Address: "10.0.0.1", Port: 50051, Weight: 1},
+				{Address: "10.0.1.1", Port: 50051, Weight: 1, Canary: true},
+			},
+			Rules: rules,
+			GRPC:  grpc,
+		}
+	}
+	rules := []api.HTTPRule{{Header: "X-Canary", Value: "always"}}
+
+	tests := []struct {
+		name    string
+		service api.HTTPService
+		want    []string
+		notWant []string
+	}{
+		{
+			"grpc",
+			service(8080, true, nil),
+			[]string{"listen                  8080 http2;", "grpc_pass               grpc://http-8080-test-greeter-50051;"},
+			[]string{"proxy_pass"},
+		},
+		{
+			"grpc with rules",
+			service(8080, true, rules),
+			[]string{"listen                  8080 http2;", "grpc_pass               grpc://$canary_upstream_8080;"},
+			[]string{"proxy_pass"},
+		},
+		{
+			"http",
+			service(8080, false, nil),
+			[]string{"listen                  8080;", "proxy_pass"},
+			[]string{"http2", "grpc_pass"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.NewDefaultTemplateConfig()
+			cfg.HTTPBackends = []api.HTTPService{tt.service}
+			buf := &bytes.Buffer{}
+			if err := tmpl.Execute(buf, cfg); err != nil {
+				t.Fatalf("Error render template: %v", err)
+			}
+			conf := buf.String()
+			for _, want := range tt.want {
+				if !strings.Contains(conf, want) {
+					t.Errorf("rendered config doesn't contain %q", want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(conf, notWant) {
+					t.Errorf("rendered config contains %q", notWant)
+				}
+			}
+		})
+	}
+}
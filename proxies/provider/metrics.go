@@ -0,0 +1,196 @@
+package provider
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+
+	log "github.com/zoumo/logdog"
+)
+
+const (
+	// SideOrigin labels the traffic to origin
+	SideOrigin = "origin"
+	// SideCanary labels the traffic to canary
+	SideCanary = "canary"
+)
+
+// LatencyBuckets are the upper bounds in seconds of the upstream latency histogram
+var LatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// StatsCollector is implemented by providers which collect the traffic stats
+type StatsCollector interface {
+	// Stats returns the stats of each side of all ports
+	Stats() ([]SideStats, error)
+}
+
+// SideStats is the traffic stats of a side of a service port
+type SideStats struct {
+	// Service is the name of the service
+	Service string
+	// Port is the port of the service
+	Port int32
+	// Side is origin or canary
+	Side string
+	// Requests is the count of HTTP requests
+	Requests uint64
+	// Connections is the count of TCP connections or UDP sessions
+	Connections uint64
+	// Errors is the count of 5xx responses or failed connections
+	Errors uint64
+	// Failures is the count of failed tries to endpoints, failed tries
+	// to canary are retried on origin
+	Failures uint64
+	// Active is the number of HTTP requests, TCP connections or UDP sessions in progress
+	Active uint64
+	// BytesReceived is the bytes received from clients
+	BytesReceived uint64
+	// BytesSent is the bytes sent to clients
+	BytesSent uint64
+	// Latency is the upstream response time of HTTP requests
+	// or the upstream connect time of connections
+	Latency Histogram
+}
+
+// Histogram is a histogram of LatencyBuckets
+type Histogram struct {
+	// Buckets are the counts of observations in each bucket, not cumulative
+	Buckets []uint64
+	// Sum of all observations
+	Sum float64
+	// Count of all observations, including the ones out of all buckets
+	Count uint64
+}
+
+// Observe adds an observation to the histogram, it's not thread safe
+func (h *Histogram) Observe(v float64) {
+	if len(h.Buckets) != len(LatencyBuckets) {
+		h.Buckets = make([]uint64, len(LatencyBuckets))
+	}
+	for i, le := range LatencyBuckets {
+		if v <= le {
+			h.Buckets[i]++
+			break
+		}
+	}
+	h.Sum += v
+	h.Count++
+}
+
+type metricFamily struct {
+	name  string
+	help  string
+	value func(s *SideStats) uint64
+}
+
+var counterFamilies = []metricFamily{
+	{
+		"canary_proxy_requests_total",
+		"Total number of HTTP requests proxied to each side.",
+		func(s *SideStats) uint64 { return s.Requests },
+	},
+	{
+		"canary_proxy_connections_total",
+		"Total number of TCP connections or UDP sessions proxied to each side.",
+		func(s *SideStats) uint64 { return s.Connections },
+	},
+	{
+		"canary_proxy_errors_total",
+		"Total number of 5xx responses or failed connections of each side.",
+		func(s *SideStats) uint64 { return s.Errors },
+	},
+	{
+		"canary_proxy_failures_total",
+		"Total number of failed tries to endpoints of each side.",
+		func(s *SideStats) uint64 { return s.Failures },
+	},
+	{
+		"canary_proxy_received_bytes_total",
+		"Total bytes received from clients of each side.",
+		func(s *SideStats) uint64 { return s.BytesReceived },
+	},
+	{
+		"canary_proxy_sent_bytes_total",
+		"Total bytes sent to clients of each side.",
+		func(s *SideStats) uint64 { return s.BytesSent },
+	},
+}
+
+var gaugeFamilies = []metricFamily{
+	{
+		"canary_proxy_active_connections",
+		"Number of HTTP requests, TCP connections or UDP sessions in progress of each side.",
+		func(s *SideStats) uint64 { return s.Active },
+	},
+}
+
+// WriteMetrics writes the stats in Prometheus text format
+func WriteMetrics(w io.Writer, stats []SideStats) error {
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Service != stats[j].Service {
+			return stats[i].Service < stats[j].Service
+		}
+		if stats[i].Port != stats[j].Port {
+			return stats[i].Port < stats[j].Port
+		}
+		return stats[i].Side < stats[j].Side
+	})
+
+	var err error
+	printf := func(format string, a ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, a...)
+		}
+	}
+
+	for _, f := range counterFamilies {
+		printf("# HELP %s %s\n# TYPE %s counter\n", f.name, f.help, f.name)
+		for i := range stats {
+			printf("%s{%s} %d\n", f.name, labels(&stats[i]), f.value(&stats[i]))
+		}
+	}
+	for _, f := range gaugeFamilies {
+		printf("# HELP %s %s\n# TYPE %s gauge\n", f.name, f.help, f.name)
+		for i := range stats {
+			printf("%s{%s} %d\n", f.name, labels(&stats[i]), f.value(&stats[i]))
+		}
+	}
+
+	name := "canary_proxy_upstream_latency_seconds"
+	printf("# HELP %s Upstream response time of HTTP requests or connect time of connections.\n# TYPE %s histogram\n", name, name)
+	for i := range stats {
+		s := &stats[i]
+		var cumulative uint64
+		for j, le := range LatencyBuckets {
+			if j < len(s.Latency.Buckets) {
+				cumulative += s.Latency.Buckets[j]
+			}
+			printf("%s_bucket{%s,le=\"%s\"} %d\n", name, labels(s), strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		printf("%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels(s), s.Latency.Count)
+		printf("%s_sum{%s} %s\n", name, labels(s), strconv.FormatFloat(s.Latency.Sum, 'g', -1, 64))
+		printf("%s_count{%s} %d\n", name, labels(s), s.Latency.Count)
+	}
+
+	return err
+}
+
+func labels(s *SideStats) string {
+	return fmt.Sprintf("service=%q,port=\"%d\",side=%q", s.Service, s.Port, s.Side)
+}
+
+// MetricsHandler returns the handler serving the stats in Prometheus text format
+func MetricsHandler(c StatsCollector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats, err := c.Stats()
+		if err != nil {
+			log.Error("Error collect traffic stats", log.Fields{"err": err})
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_ = WriteMetrics(w, stats)
+	})
+}
@@ -0,0 +1,44 @@
+package provider
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	canary := SideStats{Service: "web", Port: 80, Side: SideCanary, Requests: 1, Errors: 1}
+	canary.Latency.Observe(0.02)
+	stats := []SideStats{
+		canary,
+		{Service: "web", Port: 80, Side: SideOrigin, Requests: 9, BytesSent: 1024, Active: 2},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteMetrics(&buf, stats); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+
+	for _, want := range []string{
+		"# TYPE canary_proxy_requests_total counter\n" +
+			"canary_proxy_requests_total{service=\"web\",port=\"80\",side=\"canary\"} 1\n" +
+			"canary_proxy_requests_total{service=\"web\",port=\"80\",side=\"origin\"} 9\n",
+		"canary_proxy_errors_total{service=\"web\",port=\"80\",side=\"canary\"} 1\n",
+		"canary_proxy_sent_bytes_total{service=\"web\",port=\"80\",side=\"origin\"} 1024\n",
+		"# TYPE canary_proxy_active_connections gauge\n" +
+			"canary_proxy_active_connections{service=\"web\",port=\"80\",side=\"canary\"} 0\n" +
+			"canary_proxy_active_connections{service=\"web\",port=\"80\",side=\"origin\"} 2\n",
+		"# TYPE canary_proxy_upstream_latency_seconds histogram\n",
+		"canary_proxy_upstream_latency_seconds_bucket{service=\"web\",port=\"80\",side=\"canary\",le=\"0.01\"} 0\n",
+		"canary_proxy_upstream_latency_seconds_bucket{service=\"web\",port=\"80\",side=\"canary\",le=\"0.025\"} 1\n",
+		"canary_proxy_upstream_latency_seconds_bucket{service=\"web\",port=\"80\",side=\"canary\",le=\"10\"} 1\n",
+		"canary_proxy_upstream_latency_seconds_bucket{service=\"web\",port=\"80\",side=\"canary\",le=\"+Inf\"} 1\n",
+		"canary_proxy_upstream_latency_seconds_sum{service=\"web\",port=\"80\",side=\"canary\"} 0.02\n",
+		"canary_proxy_upstream_latency_seconds_bucket{service=\"web\",port=\"80\",side=\"origin\",le=\"+Inf\"} 0\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("WriteMetrics() = %v, want to contain %v", got, want)
+		}
+	}
+}
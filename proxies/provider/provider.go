@@ -0,0 +1,87 @@
+package provider
+
+import (
+	"reflect"
+
+	"github.com/caicloud/canary-release/pkg/api"
+)
+
+// TrafficProvider is the data plane which splits the traffic of a canary
+// release between the origin and canary services. Proxy computes the
+// desired split and a provider applies it.
+type TrafficProvider interface {
+	// Start starts the data plane, it blocks until the data plane exits
+	Start()
+	// Stop stops the data plane gracefully
+	Stop() error
+	// Apply applies the desired split to the running data plane
+	Apply(split Split) error
+	// Health returns an error if the data plane is not serving traffic
+	Health() error
+}
+
+// Split describes the desired traffic split of all ports of a canary release
+type Split struct {
+	HTTP []api.HTTPService
+	TCP  []api.L4Service
+	UDP  []api.L4Service
+	// Options tune the data plane, they are the data of the ConfigMap
+	// of the canary release. Providers ignore options they don't know.
+	Options map[string]string
+}
+
+// Equal tests for equality between two Split types
+func (s *Split) Equal(s2 *Split) bool {
+	if s == s2 {
+		return true
+	}
+	if s == nil || s2 == nil {
+		return false
+	}
+
+	if len(s.Options) != len(s2.Options) ||
+		(len(s.Options) > 0 && !reflect.DeepEqual(s.Options, s2.Options)) {
+		return false
+	}
+
+	if len(s.HTTP) != len(s2.HTTP) {
+		return false
+	}
+
+	for _, s1b := range s.HTTP {
+		found := false
+		for _, s2b := range s2.HTTP {
+			if s1b.Equal(s2b) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return l4ServicesEqual(s.TCP, s2.TCP) && l4ServicesEqual(s.UDP, s2.UDP)
+}
+
+// l4ServicesEqual tests for equality between two L4Service slices
+// regardless of their order
+func l4ServicesEqual(s1, s2 []api.L4Service) bool {
+	if len(s1) != len(s2) {
+		return false
+	}
+
+	for _, s1b := range s1 {
+		found := false
+		for _, s2b := range s2 {
+			if s1b.Equal(s2b) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}